@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+)
+
+// accountDeletionBatch caps how many accounts one run of the deletion job erases
+const accountDeletionBatch = 100
+
+// accountExport is the archive handed to a user asking for a copy of their data
+type accountExport struct {
+	ExportedAt          time.Time         `json:"exported_at"`
+	Account             store.User        `json:"account"`
+	DeletionScheduledAt *time.Time        `json:"deletion_scheduled_at"`
+	Profile             store.UserProfile `json:"profile"`
+	store.AccountData
+	LoginSessions []store.AuthSession `json:"login_sessions"`
+}
+
+// ExportAccount returns everything stored about the signed in user as a JSON
+// download
+func (app *application) ExportAccount(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+	ctx := r.Context()
+
+	export := accountExport{
+		ExportedAt: time.Now().UTC(),
+		Account:    user,
+	}
+
+	var err error
+	export.DeletionScheduledAt, err = app.store.Account.GetDeletionSchedule(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	export.Profile, err = app.store.User.GetProfile(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	app.setAvatarURLs(&export.Profile)
+
+	export.AccountData, err = app.store.Account.ExportAccountData(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	export.LoginSessions, err = app.store.Auth.ListSessions(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="studygroup-export-%d-%s.json"`, user.ID, export.ExportedAt.Format("20060102")))
+	w.Header().Set("Cache-Control", "no-store")
+
+	app.writeJSON(w, http.StatusOK, "account exported successfully", export)
+}
+
+// GetAccountDeletion reports whether the signed in user's account is due to be
+// deleted, and when
+func (app *application) GetAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	scheduledAt, err := app.store.Account.GetDeletionSchedule(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "account deletion fetched successfully", map[string]*time.Time{
+		"deletion_scheduled_at": scheduledAt,
+	})
+}
+
+// ScheduleAccountDeletion starts the grace period after which the signed in
+// user's account is erased. The account keeps working until then, so the user
+// can still sign in and cancel.
+func (app *application) ScheduleAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	scheduledAt, err := app.store.Account.ScheduleDeletion(r.Context(), user.ID, time.Now().Add(app.config.auth.deletionGrace))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// Sending retries for several seconds when the mail server is down
+	app.background(fmt.Sprintf("account deletion notice for user %d", user.ID), func() error {
+		return app.mailer.Send(mailer.AccountDeletionTemplate, user.Email, map[string]string{
+			"FirstName":    user.FirstName,
+			"DeletionDate": scheduledAt.UTC().Format("2 January 2006 at 15:04 MST"),
+			"SettingsURL":  app.config.frontendURL + "/settings",
+		})
+	})
+
+	app.writeJSON(w, http.StatusAccepted, "account deletion scheduled", map[string]time.Time{
+		"deletion_scheduled_at": scheduledAt,
+	})
+}
+
+func (app *application) CancelAccountDeletion(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	err := app.store.Account.CancelDeletion(r.Context(), user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("no account deletion is pending"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "account deletion cancelled", nil)
+}
+
+// deleteDueAccounts erases the accounts whose grace period has ended, along
+// with their images
+func (app *application) deleteDueAccounts(ctx context.Context) error {
+	userIDs, err := app.store.Account.GetAccountsDueForDeletion(ctx, time.Now(), accountDeletionBatch)
+	if err != nil {
+		return err
+	}
+
+	for _, userID := range userIDs {
+		imageKeys, err := app.store.Account.DeleteAccount(ctx, userID)
+		if err != nil {
+			// Cancelled since it was listed
+			if errors.Is(err, store.ErrNotFound) {
+				continue
+			}
+			return fmt.Errorf("deleting user %d: %w", userID, err)
+		}
+
+		for _, key := range imageKeys {
+			app.deleteImage(ctx, key)
+		}
+		log.Printf("deleted account of user %d", userID)
+	}
+
+	return nil
+}
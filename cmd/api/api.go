@@ -4,15 +4,25 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/RakibulBh/studygroup-backend/internal/blob"
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
+	"github.com/RakibulBh/studygroup-backend/internal/signing"
+	"github.com/RakibulBh/studygroup-backend/internal/sso"
 	"github.com/RakibulBh/studygroup-backend/internal/store"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/go-webauthn/webauthn/webauthn"
 )
 
 type application struct {
-	config config
-	store  store.Storage
+	config   config
+	store    store.Storage
+	mailer   mailer.Client
+	sso      sso.Registry
+	keys     *signing.KeySet
+	webauthn *webauthn.WebAuthn
+	blobs    blob.Store
 }
 
 type dbConfig struct {
@@ -23,59 +33,232 @@ type dbConfig struct {
 }
 
 type config struct {
-	addr   string
-	db     dbConfig
-	auth   auth
-	env    string
-	apiURL string
+	addr        string
+	db          dbConfig
+	auth        auth
+	mail        mailConfig
+	oidc        oidcConfig
+	webauthn    webauthnConfig
+	cookie      cookieConfig
+	proxies     proxyConfig
+	cors        corsConfig
+	uploads     uploadConfig
+	login       loginConfig
+	env         string
+	apiURL      string
+	frontendURL string
 }
 
 type auth struct {
-	jwtSecret  string
-	exp        time.Duration
-	refreshExp time.Duration
+	keysDir      string
+	signingKeyID string
+	// issuer and audience are the iss and aud of every token
+	issuer       string
+	audience     string
+	exp          time.Duration
+	refreshExp   time.Duration
+	verifyExp    time.Duration
+	resetExp     time.Duration
+	mfaExp       time.Duration
+	magicLinkExp time.Duration
+	passkeyExp   time.Duration
+	// deletionGrace is how long a deleted account can still be restored
+	deletionGrace time.Duration
+}
+
+type oidcConfig struct {
+	providers []sso.ProviderConfig
+	stateExp  time.Duration
+}
+
+type uploadConfig struct {
+	maxBytes int64
+	// backend is "local" or "s3"
+	backend  string
+	localDir string
+	s3       blob.S3Config
+}
+
+type corsConfig struct {
+	allowedOrigins []string
+}
+
+// webauthnConfig identifies the relying party passkeys are bound to. The RP ID
+// is a registrable domain, passkeys only work on origins under it.
+type webauthnConfig struct {
+	rpID      string
+	rpOrigins []string
+}
+
+type mailConfig struct {
+	fromEmail    string
+	smtpHost     string
+	smtpPort     int
+	smtpUsername string
+	smtpPassword string
 }
 
 func (app *application) mount() http.Handler {
 	r := chi.NewRouter()
 
 	r.Use(middleware.RequestID)
-	r.Use(middleware.RealIP)
+	r.Use(app.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
-	// CORS
+	// CORS, credentials are allowed so cookie mode works, which is why only
+	// allowlisted origins may make cross-origin requests
 	r.Use(cors.Handler(cors.Options{
-		// AllowedOrigins:   []string{"https://foo.com"}, // Use this to allow specific origin hosts
-		AllowedOrigins: []string{"https://*", "http://*"},
-		// AllowOriginFunc:  func(r *http.Request, origin string) bool { return true },
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: false,
+		AllowedOrigins:   app.config.cors.allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", csrfHeaderName, authModeHeader},
+		ExposedHeaders:   []string{"Link", "Retry-After"},
+		AllowCredentials: true,
 		MaxAge:           300, // Maximum value not ignored by any of major browsers
 	}))
 
+	// Uploaded files, when they are stored on this server
+	if local, ok := app.blobs.(*blob.Local); ok {
+		r.Mount("/media", http.StripPrefix("/media", local.Handler()))
+	}
+
 	// Healthcheck
 	r.Route("/health", func(r chi.Router) {
 		r.Get("/", app.Healthcheck)
 	})
 
+	// Public keys for verifying our access tokens
+	r.Get("/.well-known/jwks.json", app.GetJWKS)
+
 	r.Route("/v1", func(r chi.Router) {
 		r.Route("/auth", func(r chi.Router) {
 			r.Post("/register", app.Register)
 			r.Post("/login", app.Login)
-			// r.Post("/logout", app.Logout)
-			r.Get("/refresh", app.Refresh)
+			r.Post("/logout", app.Logout)
+			r.Get("/csrf", app.GetCSRFToken)
+
+			r.Route("/refresh", func(r chi.Router) {
+				r.Use(app.RequireCSRFToken)
+				r.Get("/", app.Refresh)
+				r.Post("/", app.Refresh)
+				// The refresh cookie is only sent to this path, so cookie mode
+				// clients log out here
+				r.Delete("/", app.Logout)
+			})
+
+			r.With(app.Authenticate, app.RejectAPIKeys).Post("/logout/all", app.LogoutAll)
+			r.Get("/verify-email", app.VerifyEmail)
+			r.With(app.Authenticate, app.RejectAPIKeys).Post("/verify-email/resend", app.ResendVerificationEmail)
+
+			r.Route("/password", func(r chi.Router) {
+				r.Post("/forgot", app.ForgotPassword)
+				r.Post("/reset", app.ResetPassword)
+				r.With(app.Authenticate, app.RejectAPIKeys).Post("/change", app.ChangePassword)
+			})
+
+			r.Route("/magic-link", func(r chi.Router) {
+				r.Post("/", app.RequestMagicLink)
+				r.Post("/verify", app.ConsumeMagicLink)
+			})
+
+			r.Route("/passkeys", func(r chi.Router) {
+				r.Post("/login/begin", app.BeginPasskeyLogin)
+				r.Post("/login/finish", app.FinishPasskeyLogin)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.Authenticate)
+					r.Use(app.RejectAPIKeys)
+					r.Get("/", app.GetPasskeys)
+					r.Post("/register/begin", app.BeginPasskeyRegistration)
+					r.Post("/register/finish", app.FinishPasskeyRegistration)
+					r.Delete("/{passkeyID}", app.DeletePasskey)
+				})
+			})
+
+			r.Route("/oidc", func(r chi.Router) {
+				r.Get("/providers", app.GetSSOProviders)
+				r.Get("/{provider}/login", app.SSOLogin)
+				r.Post("/{provider}/callback", app.SSOCallback)
+			})
+
+			r.Route("/sessions", func(r chi.Router) {
+				r.Use(app.Authenticate)
+				r.Use(app.RejectAPIKeys)
+				r.Get("/", app.GetAuthSessions)
+				r.Delete("/{sessionID}", app.RevokeAuthSession)
+			})
+
+			r.Route("/api-keys", func(r chi.Router) {
+				r.Use(app.Authenticate)
+				r.Use(app.RejectAPIKeys)
+				r.Get("/", app.GetAPIKeys)
+				r.Post("/", app.CreateAPIKey)
+				r.Delete("/{keyID}", app.RevokeAPIKey)
+			})
+
+			r.Route("/2fa", func(r chi.Router) {
+				r.Post("/verify", app.VerifyMFA)
+
+				r.Group(func(r chi.Router) {
+					r.Use(app.Authenticate)
+					r.Use(app.RejectAPIKeys)
+					r.Post("/enroll", app.EnrollTOTP)
+					r.Post("/confirm", app.ConfirmTOTP)
+					r.Post("/recovery-codes", app.RegenerateRecoveryCodes)
+					r.Post("/disable", app.DisableTOTP)
+				})
+			})
+		})
+
+		r.Route("/universities", func(r chi.Router) {
+			r.Get("/", app.GetUniversities)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.Authenticate)
+				r.Use(app.RejectAPIKeys)
+				r.Use(app.RequireSiteAdmin)
+				r.Post("/", app.CreateUniversity)
+				r.Post("/{universityID}/merge", app.MergeUniversity)
+			})
 		})
 
 		r.Route("/user", func(r chi.Router) {
 			r.Use(app.Authenticate)
+			r.Use(app.RequireScope(store.ScopeUserRead, store.ScopeUserWrite))
 			r.Get("/", app.GetUser)
+			r.Patch("/", app.UpdateUser)
+			r.Get("/profile", app.GetProfile)
+			r.Put("/avatar", app.UploadAvatar)
+			r.Delete("/avatar", app.DeleteAvatar)
+
+			r.Get("/blocks", app.GetBlockedUsers)
+			r.Put("/blocks/{userID}", app.BlockUser)
+			r.Delete("/blocks/{userID}", app.UnblockUser)
+			r.Get("/mutes", app.GetMutedUsers)
+			r.Put("/mutes/{userID}", app.MuteUser)
+			r.Delete("/mutes/{userID}", app.UnmuteUser)
+
+			r.Group(func(r chi.Router) {
+				r.Use(app.RejectAPIKeys)
+				r.Get("/export", app.ExportAccount)
+				r.Get("/deletion", app.GetAccountDeletion)
+				r.Post("/deletion", app.ScheduleAccountDeletion)
+				r.Delete("/deletion", app.CancelAccountDeletion)
+			})
+		})
+
+		r.Route("/users", func(r chi.Router) {
+			r.Use(app.Authenticate)
+			r.Use(app.RequireVerifiedEmail)
+			r.Use(app.RequireScope(store.ScopeUserRead, store.ScopeUserWrite))
+			r.Get("/", app.SearchUsers)
+			r.Get("/{userID}", app.GetPublicProfile)
 		})
 
 		r.Route("/groups", func(r chi.Router) {
 			r.Use(app.Authenticate)
+			r.Use(app.RequireVerifiedEmail)
+			r.Use(app.RequireScope(store.ScopeGroupsRead, store.ScopeGroupsWrite))
 			r.Get("/all", app.GetAllGroups)
 			r.Get("/", app.GetUserGroups)
 			r.Get("/joined", app.GetJoinedGroups)
@@ -87,16 +270,45 @@ func (app *application) mount() http.Handler {
 				r.Get("/", app.GetGroup)
 				r.Post("/join", app.JoinGroup)
 				r.Post("/leave", app.LeaveGroup)
-				r.Post("/invite", app.InviteUserToGroup)
+				r.With(app.RequireGroupPermission(store.PermissionInvite)).Post("/invite", app.InviteUserToGroup)
 				r.Get("/members", app.GetGroupMembers)
+				r.Route("/members/{userID}", func(r chi.Router) {
+					r.With(app.RequireGroupPermission(store.PermissionRemoveMembers)).Delete("/", app.RemoveGroupMember)
+					r.With(app.RequireGroupPermission(store.PermissionManageRoles)).Post("/promote", app.PromoteMember)
+					r.With(app.RequireGroupPermission(store.PermissionManageRoles)).Post("/demote", app.DemoteMember)
+				})
+				r.Route("/bans", func(r chi.Router) {
+					r.Use(app.RequireGroupPermission(store.PermissionBanMembers))
+					r.Get("/", app.GetGroupBans)
+					r.Put("/{userID}", app.BanGroupMember)
+					r.Delete("/{userID}", app.UnbanGroupMember)
+				})
+				r.Route("/transfer", func(r chi.Router) {
+					r.Get("/", app.GetGroupOwnershipTransfer)
+					r.With(app.RequireGroupPermission(store.PermissionTransferGroup)).Post("/", app.OfferGroupOwnership)
+					r.With(app.RequireGroupPermission(store.PermissionTransferGroup)).Delete("/", app.CancelGroupOwnershipTransfer)
+					r.Post("/accept", app.AcceptGroupOwnership)
+					r.Post("/decline", app.DeclineGroupOwnership)
+				})
 				r.Get("/is-admin", app.IsAdmin)
-				r.Delete("/", app.DeleteGroup)
+				r.Get("/role", app.GetGroupRole)
+				r.With(app.RequireGroupPermission(store.PermissionDeleteGroup)).Delete("/", app.DeleteGroup)
+				r.Group(func(r chi.Router) {
+					r.Use(app.RequireGroupPermission(store.PermissionEditGroup))
+					r.Patch("/", app.UpdateGroup)
+					r.Get("/changes", app.GetGroupChanges)
+					r.Put("/cover", app.UploadGroupCover)
+					r.Delete("/cover", app.DeleteGroupCover)
+				})
 				r.Route("/requests", func(r chi.Router) {
+					r.Use(app.RequireGroupPermission(store.PermissionApproveRequests))
 					r.Get("/", app.GetJoinRequests)
 					r.Post("/approve", app.ApproveJoinRequest)
 				})
 			})
 
+			r.Get("/transfers", app.GetOwnershipOffers)
+
 			r.Route("/invitations", func(r chi.Router) {
 				r.Get("/", app.GetUserInvitations)
 				r.Post("/resolve", app.ResolveInvitation)
@@ -106,7 +318,9 @@ func (app *application) mount() http.Handler {
 
 		r.Route("/sessions", func(r chi.Router) {
 			r.Use(app.Authenticate)
-			r.Post("/{groupID}", app.CreateStudySession)
+			r.Use(app.RequireVerifiedEmail)
+			r.Use(app.RequireScope(store.ScopeSessionsRead, store.ScopeSessionsWrite))
+			r.With(app.RequireGroupPermission(store.PermissionCreateSessions)).Post("/{groupID}", app.CreateStudySession)
 			r.Get("/{groupID}", app.GetGroupStudySessions)
 			r.Get("/user", app.GetUserStudySessions)
 		})
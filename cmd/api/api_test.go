@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
+	"github.com/RakibulBh/studygroup-backend/internal/signing"
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newTestApplication builds an application against the test database, it is
+// skipped when there is none
+func newTestApplication(t *testing.T) *application {
+	t.Helper()
+
+	conn := testdb.Open(t)
+
+	keys, err := signing.Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &application{
+		config: config{
+			auth: auth{
+				exp:          time.Minute,
+				refreshExp:   time.Hour,
+				verifyExp:    time.Hour,
+				resetExp:     time.Hour,
+				mfaExp:       time.Minute,
+				magicLinkExp: time.Minute,
+				passkeyExp:   time.Minute,
+			},
+			oidc:        oidcConfig{stateExp: time.Minute},
+			cookie:      cookieConfig{sameSite: http.SameSiteLaxMode},
+			env:         "test",
+			apiURL:      "http://localhost:8080",
+			frontendURL: "http://localhost:3000",
+		},
+		store:  store.NewStorage(conn),
+		mailer: mailer.NewLog(),
+		keys:   keys,
+	}
+}
+
+type requestOption func(r *http.Request)
+
+func withCookie(cookie *http.Cookie) requestOption {
+	return func(r *http.Request) {
+		r.AddCookie(cookie)
+	}
+}
+
+func withAccessToken(accessToken string) requestOption {
+	return func(r *http.Request) {
+		r.Header.Set("Authorization", "Bearer "+accessToken)
+	}
+}
+
+// serve sends a request through the application's router
+func (app *application) serve(t *testing.T, method string, target string, body any, options ...requestOption) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		err := json.NewEncoder(&buf).Encode(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := httptest.NewRequest(method, target, &buf)
+	r.Header.Set("Content-Type", "application/json")
+	for _, option := range options {
+		option(r)
+	}
+
+	w := httptest.NewRecorder()
+	app.mount().ServeHTTP(w, r)
+
+	return w
+}
+
+// decodeData unmarshals the data field of a response
+func decodeData(t *testing.T, w *httptest.ResponseRecorder, data any) {
+	t.Helper()
+
+	response := jsonResponse{Data: data}
+	err := json.Unmarshal(w.Body.Bytes(), &response)
+	if err != nil {
+		t.Fatalf("decoding response %q: %v", w.Body.String(), err)
+	}
+}
+
+// tokenUserID returns the user an access token was issued to
+func (app *application) tokenUserID(t *testing.T, accessToken string) int {
+	t.Helper()
+
+	token, err := app.keys.Parse(accessToken)
+	if err != nil {
+		t.Fatalf("parsing access token: %v", err)
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		t.Fatal("unexpected claims type")
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		t.Fatalf("access token has no user_id: %v", claims)
+	}
+
+	return int(userID)
+}
+
+// login signs the user in and returns an access token
+func (app *application) login(t *testing.T, userID int) string {
+	t.Helper()
+
+	accessToken, _, err := app.issueTokens(httptest.NewRequest(http.MethodPost, "/v1/auth/login", nil), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return accessToken
+}
+
+// createUser registers a user directly in the store
+func (app *application) createUser(t *testing.T, email string, password string, verified bool) int {
+	t.Helper()
+
+	ctx := context.Background()
+
+	university, err := app.store.University.UniversityForEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := app.store.Auth.HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := app.store.Auth.Register(ctx, store.RegisterRequest{
+		FirstName:    "Test",
+		LastName:     "User",
+		Email:        email,
+		UniversityID: university.ID,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if verified {
+		err = app.store.Auth.VerifyEmail(ctx, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return userID
+}
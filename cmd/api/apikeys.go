@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxAPIKeyNameLength = 100
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// ExpiresAt is optional, keys without it never expire
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreateAPIKey mints a personal API key for scripts and bots. The raw key is
+// only returned here, it cannot be fetched again.
+func (app *application) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload CreateAPIKeyRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(payload.Name) == 0 || len(payload.Name) > maxAPIKeyNameLength {
+		app.badRequestResponse(w, r, fmt.Errorf("name must be between 1 and %d characters", maxAPIKeyNameLength))
+		return
+	}
+	if len(payload.Scopes) == 0 {
+		app.badRequestResponse(w, r, errors.New("at least one scope is required"))
+		return
+	}
+	for _, scope := range payload.Scopes {
+		if !slices.Contains(store.Scopes, scope) {
+			app.badRequestResponse(w, r, fmt.Errorf("unknown scope %q", scope))
+			return
+		}
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		app.badRequestResponse(w, r, errors.New("expiry must be in the future"))
+		return
+	}
+
+	slices.Sort(payload.Scopes)
+	scopes := slices.Compact(payload.Scopes)
+
+	apiKey, key, err := app.store.APIKeys.CreateAPIKey(r.Context(), user.ID, payload.Name, scopes, payload.ExpiresAt)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, "api key created, store it now as it will not be shown again", map[string]any{
+		"api_key": apiKey,
+		"key":     key,
+	})
+}
+
+func (app *application) GetAPIKeys(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	apiKeys, err := app.store.APIKeys.ListAPIKeys(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api keys fetched successfully", apiKeys)
+}
+
+func (app *application) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	keyID, err := strconv.Atoi(chi.URLParam(r, "keyID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid api key id"))
+		return
+	}
+
+	err = app.store.APIKeys.RevokeAPIKey(r.Context(), user.ID, keyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("api key not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "api key revoked successfully", nil)
+}
@@ -3,22 +3,24 @@ package main
 import (
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"slices"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/RakibulBh/studygroup-backend/internal/constants"
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
 	"github.com/RakibulBh/studygroup-backend/internal/store"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
 type RegisterRequest struct {
 	FirstName       string `json:"first_name"`
 	LastName        string `json:"last_name"`
 	Email           string `json:"email"`
-	University      string `json:"university"`
+	UniversityID    int    `json:"university_id"`
 	Password        string `json:"password"`
 	PasswordConfirm string `json:"password_confirm"`
 }
@@ -53,9 +55,19 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify University exists
-	if !slices.Contains(constants.Universities, payload.University) {
-		app.badRequestResponse(w, r, errors.New("invalid university"))
+	// Verify University exists and the email belongs to it
+	university, err := app.store.University.GetUniversity(ctx, payload.UniversityID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errors.New("invalid university"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !university.AllowsEmail(payload.Email) {
+		app.badRequestResponse(w, r, errors.New("email address does not belong to the selected university"))
 		return
 	}
 
@@ -73,13 +85,74 @@ func (app *application) Register(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// store the user in the database
-	err = app.store.Auth.Register(ctx, store.RegisterRequest{FirstName: payload.FirstName, LastName: payload.LastName, Email: payload.Email, University: payload.University, PasswordHash: hash})
+	userID, err := app.store.Auth.Register(ctx, store.RegisterRequest{FirstName: payload.FirstName, LastName: payload.LastName, Email: payload.Email, UniversityID: university.ID, PasswordHash: hash})
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// The account exists either way, a failed email can be resent later
+	err = app.sendVerificationEmail(userID, payload.FirstName, payload.Email)
+	if err != nil {
+		log.Printf("failed to send verification email to user %d: %v", userID, err)
+	}
+
+	app.writeJSON(w, http.StatusCreated, "registered successfully, please verify your email address", nil)
+}
+
+const verifyEmailAction = "verify_email"
+
+func (app *application) sendVerificationEmail(userID int, firstName string, email string) error {
+	token, err := app.store.Auth.GenerateActionToken(userID, verifyEmailAction, time.Now().Add(app.config.auth.verifyExp), app.keys)
+	if err != nil {
+		return err
+	}
+
+	return app.mailer.Send(mailer.VerifyEmailTemplate, email, map[string]string{
+		"FirstName":       firstName,
+		"VerificationURL": fmt.Sprintf("%s/v1/auth/verify-email?token=%s", app.config.apiURL, url.QueryEscape(token)),
+		"ExpiresIn":       fmt.Sprintf("%d hours", int(app.config.auth.verifyExp.Hours())),
+	})
+}
+
+// VerifyEmail consumes the link sent by sendVerificationEmail
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		app.badRequestResponse(w, r, errors.New("verification token is required"))
+		return
+	}
+
+	userID, err := app.store.Auth.VerifyActionToken(token, verifyEmailAction, app.keys)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid or expired verification link"))
+		return
+	}
+
+	err = app.store.Auth.VerifyEmail(r.Context(), userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "email verified successfully", nil)
+}
+
+func (app *application) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	if user.EmailVerified {
+		app.badRequestResponse(w, r, errors.New("email address is already verified"))
+		return
+	}
+
+	err := app.sendVerificationEmail(user.ID, user.FirstName, user.Email)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusCreated, "registered successfully", nil)
+	app.writeJSON(w, http.StatusOK, "verification email sent", nil)
 }
 
 type LoginRequest struct {
@@ -98,18 +171,30 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	ctx := r.Context()
+	email := normalizeEmail(payload.Email)
+	ip := clientIP(r)
 
-	// Fetch user from the database
-	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
+	// Refuse to check the password at all while the account or IP is throttled
+	attempt, retryAfter, err := app.beginLoginAttempt(ctx, email, ip)
 	if err != nil {
-		switch {
-		case errors.Is(err, store.ErrNoRows):
-			app.badRequestResponse(w, r, errors.New("user does not exist"))
-			return
-		default:
-			app.internalServerErrorResponse(w, r, err)
-			return
-		}
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyRequestsResponse(w, r, retryAfter)
+		return
+	}
+
+	// Fetch user from the database, an unknown email is checked against a
+	// dummy hash and reported exactly like a wrong password
+	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNoRows) {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	userExists := err == nil
+	if !userExists {
+		user.PasswordHash = dummyPasswordHash
 	}
 
 	// Verify password matches with the database hash
@@ -119,89 +204,194 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if !passwordMatches {
-		app.badRequestResponse(w, r, errors.New("invalid credentials"))
+	if !userExists || !passwordMatches {
+		err = app.recordLoginFailure(ctx, attempt, email, ip)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		app.badRequestResponse(w, r, ErrInvalidCredentials)
 		return
 	}
 
-	// Generate a JWT token
-	accessToken, err := app.store.Auth.GenerateJWT(user.ID, time.Now().Add(app.config.auth.exp), app.config.auth.jwtSecret)
+	err = app.store.LoginAttempts.SucceedAttempt(ctx, attempt.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	// Generate a Refesh JWT token
-	refreshToken, err := app.store.Auth.GenerateJWT(user.ID, time.Now().Add(app.config.auth.refreshExp), app.config.auth.jwtSecret)
+	app.completeLogin(w, r, user.ID)
+}
+
+// completeLogin finishes any successful first factor login. With 2FA enabled
+// it only hands out a challenge token, see VerifyMFA.
+func (app *application) completeLogin(w http.ResponseWriter, r *http.Request, userID int) {
+	ctx := r.Context()
+
+	mfaEnabled, err := app.store.MFA.IsEnabled(ctx, userID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
+	if mfaEnabled {
+		mfaToken, err := app.store.Auth.GenerateActionToken(userID, mfaChallengeAction, time.Now().Add(app.config.auth.mfaExp), app.keys)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
 
-	// Store the refresh token in the database
-	err = app.store.Auth.StoreRefreshToken(ctx, user.ID, refreshToken, time.Now().Add(app.config.auth.refreshExp))
+		app.writeJSON(w, http.StatusOK, "two-factor authentication required", map[string]any{
+			"mfa_required": true,
+			"mfa_token":    mfaToken,
+		})
+		return
+	}
+
+	accessToken, refreshToken, err := app.issueTokens(r, userID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusAccepted, "logged in successfully", map[string]string{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-	})
+	app.writeTokens(w, r, http.StatusAccepted, "logged in successfully", accessToken, refreshToken)
 }
 
-func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
+// issueTokens generates an access/refresh token pair for a fresh login, starting
+// a new refresh token family for the requesting device.
+func (app *application) issueTokens(r *http.Request, userID int) (string, string, error) {
+	sessionID := uuid.NewString()
 
-	refreshToken := r.Header.Get("Authorization")
-	if refreshToken == "" {
-		app.badRequestResponse(w, r, errors.New("refresh token is required"))
-		return
+	// Generate a JWT token
+	accessToken, err := app.store.Auth.GenerateJWT(userID, sessionID, store.TokenTypeAccess, time.Now().Add(app.config.auth.exp), app.keys)
+	if err != nil {
+		return "", "", err
 	}
 
-	parts := strings.Split(refreshToken, " ")
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		app.badRequestResponse(w, r, errors.New("invalid refresh token"))
-		return
+	// Generate a Refesh JWT token
+	refreshToken, err := app.store.Auth.GenerateJWT(userID, sessionID, store.TokenTypeRefresh, time.Now().Add(app.config.auth.refreshExp), app.keys)
+	if err != nil {
+		return "", "", err
 	}
 
-	refreshToken = parts[1]
+	// Store the refresh token in the database
+	err = app.store.Auth.StoreRefreshToken(r.Context(), userID, sessionID, refreshToken, time.Now().Add(app.config.auth.refreshExp), requestDevice(r))
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessToken, refreshToken, nil
+}
+
+// readRefreshToken extracts and verifies the refresh token sent in the cookie
+// mode cookie or as a bearer token, returning the raw token and the user it
+// belongs to.
+func (app *application) readRefreshToken(r *http.Request) (string, int, error) {
+	var refreshToken string
+	if cookie, err := r.Cookie(refreshCookieName); err == nil {
+		refreshToken = cookie.Value
+	} else {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			return "", 0, errors.New("refresh token is required")
+		}
+
+		parts := strings.Split(header, " ")
+		if len(parts) != 2 || parts[0] != "Bearer" {
+			return "", 0, errors.New("invalid refresh token")
+		}
+
+		refreshToken = parts[1]
+	}
 
 	// Validate if the token is valid
-	jwtToken, err := app.store.Auth.VerifyToken(refreshToken, app.config.auth.jwtSecret)
+	jwtToken, err := app.store.Auth.VerifyToken(refreshToken, app.keys)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid token"))
-		return
+		return "", 0, errors.New("invalid token")
 	}
 
 	claims, ok := jwtToken.Claims.(jwt.MapClaims)
-	if !ok {
-		app.badRequestResponse(w, r, errors.New("invalid token"))
-		return
+	if !ok || claims["typ"] != store.TokenTypeRefresh {
+		return "", 0, errors.New("invalid token")
 	}
 
 	userID, err := strconv.ParseInt(fmt.Sprintf("%v", claims["user_id"]), 10, 64)
 	if err != nil {
-		app.badRequestResponse(w, r, errors.New("invalid token"))
+		return "", 0, errors.New("invalid token")
+	}
+
+	return refreshToken, int(userID), nil
+}
+
+func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
+
+	refreshToken, userID, err := app.readRefreshToken(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
 	ctx := r.Context()
 
 	// Verify the refresh token and regenerate
-	accessToken, refreshToken, err := app.store.Auth.RefreshToken(ctx, int(userID), refreshToken, app.config.auth.jwtSecret, app.config.auth.refreshExp, app.config.auth.exp)
+	accessToken, refreshToken, err := app.store.Auth.RefreshToken(ctx, userID, refreshToken, app.keys, app.config.auth.refreshExp, app.config.auth.exp, requestDevice(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrTokenReused):
+			log.Printf("security: refresh token reuse for user %d from %s, possible token theft; token family revoked", userID, r.RemoteAddr)
+			app.clearAuthCookies(w)
+			app.unauthorizedResponse(w, r, err)
+		case errors.Is(err, store.ErrInvalidToken):
+			app.clearAuthCookies(w)
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeTokens(w, r, http.StatusAccepted, "refreshed successfully", accessToken, refreshToken)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+	}
+}
+
+// Logout revokes the refresh token sent as a bearer token or cookie
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+
+	refreshToken, userID, err := app.readRefreshToken(r)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusAccepted, "refreshed successfully", map[string]string{
-		"access_token":  accessToken,
-		"refresh_token": refreshToken,
-	})
+	err = app.store.Auth.RevokeRefreshToken(r.Context(), userID, refreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.clearAuthCookies(w)
+	app.writeJSON(w, http.StatusOK, "logged out successfully", nil)
+}
+
+// LogoutAll revokes every refresh token of the user and every access token issued so far
+func (app *application) LogoutAll(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	err := app.store.Auth.RevokeAllTokens(r.Context(), user.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
+		return
 	}
+
+	app.clearAuthCookies(w)
+	app.writeJSON(w, http.StatusOK, "logged out of all devices successfully", nil)
 }
 
 func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
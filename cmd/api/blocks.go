@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// blockedVerbs is the past tense of each block kind, for response messages
+var blockedVerbs = map[string]string{
+	store.BlockKindBlock: "blocked",
+	store.BlockKindMute:  "muted",
+}
+
+// GetBlockedUsers lists the users the signed in user has blocked
+func (app *application) GetBlockedUsers(w http.ResponseWriter, r *http.Request) {
+	app.listBlocks(w, r, store.BlockKindBlock)
+}
+
+func (app *application) BlockUser(w http.ResponseWriter, r *http.Request) {
+	app.addBlock(w, r, store.BlockKindBlock)
+}
+
+func (app *application) UnblockUser(w http.ResponseWriter, r *http.Request) {
+	app.removeBlock(w, r, store.BlockKindBlock)
+}
+
+// GetMutedUsers lists the users the signed in user has muted
+func (app *application) GetMutedUsers(w http.ResponseWriter, r *http.Request) {
+	app.listBlocks(w, r, store.BlockKindMute)
+}
+
+func (app *application) MuteUser(w http.ResponseWriter, r *http.Request) {
+	app.addBlock(w, r, store.BlockKindMute)
+}
+
+func (app *application) UnmuteUser(w http.ResponseWriter, r *http.Request) {
+	app.removeBlock(w, r, store.BlockKindMute)
+}
+
+func (app *application) listBlocks(w http.ResponseWriter, r *http.Request, kind string) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	blocked, err := app.store.UserBlocks.ListBlocks(r.Context(), user.ID, kind)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, kind+"s fetched successfully", blocked)
+}
+
+func (app *application) addBlock(w http.ResponseWriter, r *http.Request, kind string) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	targetID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+	if targetID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you cannot "+kind+" yourself"))
+		return
+	}
+
+	err = app.store.UserBlocks.AddBlock(r.Context(), user.ID, targetID, kind)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "user "+blockedVerbs[kind]+" successfully", nil)
+}
+
+func (app *application) removeBlock(w http.ResponseWriter, r *http.Request, kind string) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	targetID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+
+	err = app.store.UserBlocks.RemoveBlock(r.Context(), user.ID, targetID, kind)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user is not "+blockedVerbs[kind]))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "user un"+blockedVerbs[kind]+" successfully", nil)
+}
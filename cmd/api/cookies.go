@@ -0,0 +1,157 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// In cookie mode the refresh token never reaches JavaScript. It lives in an
+// HttpOnly cookie that browsers only send to the refresh endpoints, and those
+// endpoints require a double-submit CSRF token. Every other route is
+// authenticated by the Authorization header, which a browser never attaches
+// on its own, so they cannot be forged cross-site.
+const (
+	refreshCookieName = "refresh_token"
+	refreshCookiePath = "/v1/auth/refresh"
+	csrfCookieName    = "csrf_token"
+	csrfHeaderName    = "X-CSRF-Token"
+
+	// Clients opt in to cookie mode per request with "X-Auth-Mode: cookie"
+	authModeHeader = "X-Auth-Mode"
+	authModeCookie = "cookie"
+)
+
+type cookieConfig struct {
+	// domain is only needed when the frontend must read the CSRF cookie from a
+	// sibling subdomain, e.g. ".studygroup.app"
+	domain   string
+	sameSite http.SameSite
+}
+
+// parseSameSite maps the AUTH_COOKIE_SAMESITE setting to its cookie attribute,
+// falling back to Lax
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+// usesCookieMode reports whether tokens for this request are handled through
+// cookies, either because the client asked for it or because it already
+// presented a refresh cookie
+func usesCookieMode(r *http.Request) bool {
+	if r.Header.Get(authModeHeader) == authModeCookie {
+		return true
+	}
+
+	_, err := r.Cookie(refreshCookieName)
+	return err == nil
+}
+
+// writeTokens sends a freshly issued token pair. In cookie mode the refresh
+// token is set as a cookie and the body carries the access token and the CSRF
+// token to send back with refresh requests.
+func (app *application) writeTokens(w http.ResponseWriter, r *http.Request, status int, message string, accessToken string, refreshToken string) error {
+	if !usesCookieMode(r) {
+		return app.writeJSON(w, status, message, map[string]string{
+			"access_token":  accessToken,
+			"refresh_token": refreshToken,
+		})
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     refreshCookieName,
+		Value:    refreshToken,
+		Path:     refreshCookiePath,
+		Domain:   app.config.cookie.domain,
+		MaxAge:   int(app.config.auth.refreshExp.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: app.config.cookie.sameSite,
+	})
+	csrfToken := app.setCSRFCookie(w)
+
+	return app.writeJSON(w, status, message, map[string]string{
+		"access_token": accessToken,
+		"csrf_token":   csrfToken,
+	})
+}
+
+// setCSRFCookie issues a new CSRF token. The cookie is readable by scripts on
+// purpose, the token is also returned in the body for frontends on another
+// domain that cannot read it.
+func (app *application) setCSRFCookie(w http.ResponseWriter) string {
+	token := rand.Text()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookieName,
+		Value:    token,
+		Path:     "/",
+		Domain:   app.config.cookie.domain,
+		MaxAge:   int(app.config.auth.refreshExp.Seconds()),
+		Secure:   true,
+		SameSite: app.config.cookie.sameSite,
+	})
+
+	return token
+}
+
+// clearAuthCookies removes the cookie mode cookies, if any were set
+func (app *application) clearAuthCookies(w http.ResponseWriter) {
+	for _, cookie := range []http.Cookie{
+		{Name: refreshCookieName, Path: refreshCookiePath, HttpOnly: true},
+		{Name: csrfCookieName, Path: "/"},
+	} {
+		cookie.Domain = app.config.cookie.domain
+		cookie.MaxAge = -1
+		cookie.Secure = true
+		cookie.SameSite = app.config.cookie.sameSite
+		http.SetCookie(w, &cookie)
+	}
+}
+
+// RequireCSRFToken checks the double-submit CSRF token on requests that carry
+// the refresh cookie: the X-CSRF-Token header must match the CSRF cookie.
+// Requests authenticated without cookies pass through.
+func (app *application) RequireCSRFToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := r.Cookie(refreshCookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(csrfCookieName)
+		header := r.Header.Get(csrfHeaderName)
+		if err != nil || header == "" || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(header)) != 1 {
+			app.forbiddenResponse(w, r, errors.New("missing or invalid csrf token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GetCSRFToken hands the current CSRF token to a frontend that lost it, e.g.
+// after a page reload. Only allowlisted origins can read the response.
+func (app *application) GetCSRFToken(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(csrfCookieName)
+	if err != nil {
+		app.writeJSON(w, http.StatusOK, "csrf token issued", map[string]string{
+			"csrf_token": app.setCSRFCookie(w),
+		})
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "csrf token fetched", map[string]string{
+		"csrf_token": cookie.Value,
+	})
+}
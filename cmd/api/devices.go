@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+)
+
+// maxUserAgentLength caps what is stored per session so clients cannot bloat
+// the refresh_tokens table with huge headers
+const maxUserAgentLength = 512
+
+// requestDevice describes the client making the request. The User-Agent is
+// client controlled, so it is made valid UTF-8 for Postgres and cut on a rune
+// boundary.
+func requestDevice(r *http.Request) store.Device {
+	userAgent := strings.ToValidUTF8(r.UserAgent(), "")
+	if len(userAgent) > maxUserAgentLength {
+		cut := maxUserAgentLength
+		for cut > 0 && !utf8.RuneStart(userAgent[cut]) {
+			cut--
+		}
+		userAgent = userAgent[:cut]
+	}
+
+	return store.Device{
+		UserAgent: userAgent,
+		IP:        clientIP(r),
+	}
+}
+
+// GetAuthSessions lists the devices the user is signed in on, flagging the one
+// making the request
+func (app *application) GetAuthSessions(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+	currentSessionID := r.Context().Value(sessionCtx).(string)
+
+	sessions, err := app.store.Auth.ListSessions(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentSessionID
+	}
+
+	app.writeJSON(w, http.StatusOK, "sessions fetched successfully", sessions)
+}
+
+// RevokeAuthSession signs one of the user's devices out. Its refresh token
+// stops working and its access tokens are rejected straight away.
+func (app *application) RevokeAuthSession(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	// Session ids are UUIDs, anything else cannot be one of the user's
+	sessionID, err := uuid.Parse(chi.URLParam(r, "sessionID"))
+	if err != nil {
+		app.notFoundResponse(w, r, errors.New("session not found"))
+		return
+	}
+
+	err = app.store.Auth.RevokeSession(r.Context(), user.ID, sessionID.String())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("session not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "session revoked successfully", nil)
+}
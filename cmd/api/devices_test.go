@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+)
+
+func TestRevokeAuthSessionRejectsMalformedID(t *testing.T) {
+	app := newTestApplication(t)
+
+	userID := app.createUser(t, testdb.Email(), "correct horse battery staple", true)
+	accessToken := app.login(t, userID)
+
+	w := app.serve(t, http.MethodDelete, "/v1/auth/sessions/not-a-session", nil, withAccessToken(accessToken))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("got status %d, want %d: %s", w.Code, http.StatusNotFound, w.Body.String())
+	}
+}
+
+func TestRequestDeviceSanitizesUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		want      string
+	}{
+		{"plain", "Mozilla/5.0", "Mozilla/5.0"},
+		{"invalid UTF-8", "Mozilla/5.0 \xff\xfe(X11)", "Mozilla/5.0 (X11)"},
+		// 2 bytes short of the limit, the next rune does not fit
+		{"over-long multi-byte", strings.Repeat("a", maxUserAgentLength-2) + strings.Repeat("€", 10), strings.Repeat("a", maxUserAgentLength-2)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("User-Agent", tt.userAgent)
+
+			got := requestDevice(r).UserAgent
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) || len(got) > maxUserAgentLength {
+				t.Errorf("got %d bytes, valid UTF-8 %t", len(got), utf8.ValidString(got))
+			}
+		})
+	}
+}
@@ -2,7 +2,10 @@ package main
 
 import (
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 var (
@@ -26,3 +29,12 @@ func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request,
 func (app *application) unauthorizedResponse(w http.ResponseWriter, r *http.Request, err error) {
 	app.errorJSON(w, err, http.StatusUnauthorized)
 }
+
+func (app *application) forbiddenResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.errorJSON(w, err, http.StatusForbidden)
+}
+
+func (app *application) tooManyRequestsResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	app.errorJSON(w, errors.New("too many attempts, please try again later"), http.StatusTooManyRequests)
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const maxBanReasonLength = 500
+
+// RemoveGroupMember takes a member out of the group. They may ask to join again.
+func (app *application) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	access := r.Context().Value(groupCtx).(groupAccess)
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+
+	err = app.store.GroupMembershipManagement.RemoveMember(r.Context(), access.ID, userID, access.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user is not a member of this group"))
+		case errors.Is(err, store.ErrConflict):
+			app.forbiddenResponse(w, r, errors.New("you can only remove members ranked below you"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Member removed successfully", nil)
+}
+
+// GetGroupBans lists the users currently banned from the group
+func (app *application) GetGroupBans(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	bans, err := app.store.GroupBans.ListBans(r.Context(), groupID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Bans fetched successfully", bans)
+}
+
+// BanGroupMemberRequest leaves out expires_at for a ban that lasts until it is
+// lifted
+type BanGroupMemberRequest struct {
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// BanGroupMember removes the user from the group, if they are in it, and keeps
+// them from joining again
+func (app *application) BanGroupMember(w http.ResponseWriter, r *http.Request) {
+	access := r.Context().Value(groupCtx).(groupAccess)
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+
+	var payload BanGroupMemberRequest
+	err = app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	payload.Reason = strings.TrimSpace(payload.Reason)
+	if len(payload.Reason) > maxBanReasonLength {
+		app.badRequestResponse(w, r, fmt.Errorf("reason must be at most %d characters", maxBanReasonLength))
+		return
+	}
+	if payload.ExpiresAt != nil && !payload.ExpiresAt.After(time.Now()) {
+		app.badRequestResponse(w, r, errors.New("expires_at must be in the future"))
+		return
+	}
+
+	user := r.Context().Value(userCtx).(store.User)
+
+	ban := store.GroupBan{
+		GroupID:   access.ID,
+		UserID:    userID,
+		BannedBy:  &user.ID,
+		Reason:    payload.Reason,
+		ExpiresAt: payload.ExpiresAt,
+	}
+
+	err = app.store.GroupBans.BanUser(r.Context(), &ban, access.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		case errors.Is(err, store.ErrConflict):
+			app.forbiddenResponse(w, r, errors.New("you can only ban members ranked below you"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "User banned successfully", ban)
+}
+
+func (app *application) UnbanGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+
+	err = app.store.GroupBans.Unban(r.Context(), groupID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user is not banned"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "User unbanned successfully", nil)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// PromoteMember makes a member of the group a moderator
+func (app *application) PromoteMember(w http.ResponseWriter, r *http.Request) {
+	app.changeRole(w, r, store.RoleModerator)
+}
+
+// DemoteMember takes a moderator back to being a plain member
+func (app *application) DemoteMember(w http.ResponseWriter, r *http.Request) {
+	app.changeRole(w, r, store.RoleMember)
+}
+
+func (app *application) changeRole(w http.ResponseWriter, r *http.Request, role string) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+
+	err = app.store.GroupMembershipManagement.ChangeRole(r.Context(), groupID, userID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user is not a member of this group"))
+		case errors.Is(err, store.ErrConflict):
+			app.errorJSON(w, errors.New("the owner's role can only change by transferring the group"), http.StatusConflict)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Member is now a "+role, nil)
+}
+
+type OfferGroupOwnershipRequest struct {
+	UserID int `json:"user_id"`
+}
+
+// OfferGroupOwnership offers the group to another member. Nothing changes until
+// they accept.
+func (app *application) OfferGroupOwnership(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	var payload OfferGroupOwnershipRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := r.Context().Value(userCtx).(store.User)
+	if payload.UserID == user.ID {
+		app.badRequestResponse(w, r, errors.New("you already own this group"))
+		return
+	}
+
+	transfer, err := app.store.GroupMembershipManagement.OfferOwnership(r.Context(), groupID, user.ID, payload.UserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("user is not a member of this group"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, "Ownership offered successfully", transfer)
+}
+
+// GetGroupOwnershipTransfer shows the group's pending ownership offer, to the
+// owner who made it and the member it was made to
+func (app *application) GetGroupOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := r.Context().Value(userCtx).(store.User)
+
+	transfer, err := app.store.GroupMembershipManagement.GetOwnershipTransfer(ctx, groupID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("no ownership transfer pending"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if transfer.ToUserID != user.ID {
+		role, err := app.store.GroupMembership.GetRole(ctx, groupID, user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		if !store.RoleCan(role, store.PermissionTransferGroup) {
+			app.notFoundResponse(w, r, errors.New("no ownership transfer pending"))
+			return
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, "Ownership transfer fetched successfully", transfer)
+}
+
+// CancelGroupOwnershipTransfer withdraws the owner's pending offer
+func (app *application) CancelGroupOwnershipTransfer(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	err := app.store.GroupMembershipManagement.CancelOwnershipTransfer(r.Context(), groupID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("no ownership transfer pending"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Ownership transfer cancelled successfully", nil)
+}
+
+// AcceptGroupOwnership takes over a group the signed in user was offered. The
+// previous owner stays on as a moderator.
+func (app *application) AcceptGroupOwnership(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := r.Context().Value(userCtx).(store.User)
+
+	err = app.store.GroupMembershipManagement.AcceptOwnershipTransfer(r.Context(), groupID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("no ownership transfer pending"))
+		case errors.Is(err, store.ErrConflict):
+			app.errorJSON(w, errors.New("this offer is no longer valid"), http.StatusConflict)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "You now own this group", nil)
+}
+
+func (app *application) DeclineGroupOwnership(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := r.Context().Value(userCtx).(store.User)
+
+	err = app.store.GroupMembershipManagement.DeclineOwnershipTransfer(r.Context(), groupID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("no ownership transfer pending"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Ownership transfer declined successfully", nil)
+}
+
+// GetOwnershipOffers lists the groups the signed in user has been offered
+func (app *application) GetOwnershipOffers(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	offers, err := app.store.GroupMembershipManagement.GetOwnershipOffers(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Ownership offers fetched successfully", offers)
+}
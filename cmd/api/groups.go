@@ -1,12 +1,18 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
+	"unicode"
 
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
 	"github.com/RakibulBh/studygroup-backend/internal/store"
 	"github.com/go-chi/chi/v5"
 )
@@ -37,24 +43,6 @@ func (app *application) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Verify empty data
-	if payload.Name == "" || payload.Description == "" || payload.Subject == "" || payload.Location == "" {
-		app.badRequestResponse(w, r, errors.New("invalid request"))
-		return
-	}
-
-	// Verify values
-	if payload.Visibility != "public" && payload.Visibility != "private" {
-		app.badRequestResponse(w, r, errors.New("invalid visibility value"))
-		return
-	}
-
-	// Verify lengths
-	if len(payload.Name) > 100 || len(payload.Description) > 500 || len(payload.Subject) > 100 || len(payload.Location) > 100 {
-		app.badRequestResponse(w, r, errors.New("invalid character limit"))
-		return
-	}
-
 	// Check if member limit being true or false
 	var memberLimit int
 	if payload.HasMemberLimit {
@@ -72,6 +60,13 @@ func (app *application) CreateGroup(w http.ResponseWriter, r *http.Request) {
 		MemberLimit:    memberLimit,
 		Subject:        payload.Subject,
 		Location:       payload.Location,
+		Visibility:     payload.Visibility,
+	}
+
+	err = validateGroup(group)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
 	}
 
 	// Create group
@@ -83,8 +78,7 @@ func (app *application) CreateGroup(w http.ResponseWriter, r *http.Request) {
 
 	group.ID = id
 
-	// Make admin
-	err = app.store.GroupMembershipManagement.MakeAdmin(ctx, group.ID, user.ID)
+	err = app.store.GroupMembershipManagement.AddOwner(ctx, group.ID, user.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
@@ -93,6 +87,40 @@ func (app *application) CreateGroup(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusOK, "Group created successfully", nil)
 }
 
+// validateGroup checks the editable fields of a group, on creation and on
+// every edit
+func validateGroup(group *store.Group) error {
+	// Verify empty data
+	if group.Name == "" || group.Description == "" || group.Subject == "" || group.Location == "" {
+		return errors.New("invalid request")
+	}
+
+	// Verify values
+	if group.Visibility != "public" && group.Visibility != "private" {
+		return errors.New("invalid visibility value")
+	}
+
+	// Verify lengths
+	if len(group.Name) > 100 || len(group.Description) > 500 || len(group.Subject) > 100 || len(group.Location) > 100 {
+		return errors.New("invalid character limit")
+	}
+
+	// Names end up in email subjects and other single line contexts
+	if hasControlCharacters(group.Name) || hasControlCharacters(group.Subject) || hasControlCharacters(group.Location) {
+		return errors.New("invalid characters")
+	}
+
+	if group.HasMemberLimit && group.MemberLimit < 1 {
+		return errors.New("member limit must be at least 1")
+	}
+
+	return nil
+}
+
+func hasControlCharacters(s string) bool {
+	return strings.ContainsFunc(s, unicode.IsControl)
+}
+
 // query
 
 func (app *application) GetUserGroups(w http.ResponseWriter, r *http.Request) {
@@ -105,6 +133,7 @@ func (app *application) GetUserGroups(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Println("Groups: ", groups)
 
+	app.setCoverURLs(groups)
 	app.writeJSON(w, http.StatusOK, "Groups fetched successfully", groups)
 }
 
@@ -128,6 +157,8 @@ func (app *application) SearchGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.setCoverURLs(groups)
+
 	var groupsWithMetadata []GroupWithMetadata
 
 	// Check if user is a member of any of the group and if they have requested
@@ -185,9 +216,124 @@ func (app *application) GetGroup(w http.ResponseWriter, r *http.Request) {
 	// Get user id from context
 	fmt.Println("Group: ", group)
 
+	// Private groups are hidden from the users banned from them
+	if group.Visibility == "private" {
+		user := r.Context().Value(userCtx).(store.User)
+		banned, err := app.store.GroupBans.IsBanned(ctx, group.ID, user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		if banned {
+			app.notFoundResponse(w, r, errors.New("group not found"))
+			return
+		}
+	}
+
+	group.CoverURL, group.CoverThumbnailURL = app.imageURLs(group.CoverKey)
+
 	app.writeJSON(w, http.StatusOK, "Group fetched successfully", group)
 }
 
+// UpdateGroupRequest changes only the fields that are sent. Setting
+// has_member_limit to false removes the limit.
+type UpdateGroupRequest struct {
+	Name           *string `json:"name"`
+	Description    *string `json:"description"`
+	HasMemberLimit *bool   `json:"has_member_limit"`
+	MemberLimit    *int    `json:"member_limit"`
+	Subject        *string `json:"subject"`
+	Location       *string `json:"location"`
+	Visibility     *string `json:"visibility"`
+}
+
+// UpdateGroup lets the owner edit a group, validated like CreateGroup. Only the
+// fields sent are changed, and every changed field is recorded in the group's
+// history.
+func (app *application) UpdateGroup(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	var payload UpdateGroupRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+	user := r.Context().Value(userCtx).(store.User)
+
+	group, changes, err := app.store.GroupRepository.UpdateGroup(ctx, groupID, store.GroupPatch(payload), user.ID, validateGroup)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalid):
+			app.badRequestResponse(w, r, err)
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("group not found"))
+		case errors.Is(err, store.ErrConflict):
+			app.errorJSON(w, errors.New("member limit cannot be lower than the current number of members"), http.StatusConflict)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	group.CoverURL, group.CoverThumbnailURL = app.imageURLs(group.CoverKey)
+
+	app.writeJSON(w, http.StatusOK, "Group updated successfully", map[string]any{
+		"group":   group,
+		"changes": changes,
+	})
+}
+
+const (
+	defaultGroupChangesLimit = 50
+	maxGroupChangesLimit     = 200
+)
+
+// GetGroupChanges shows the owner who changed which field of the group and when,
+// newest first. ?field= narrows it to one field, ?before= takes the id of the
+// last change seen to fetch the next page.
+func (app *application) GetGroupChanges(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	query := r.URL.Query()
+
+	field := query.Get("field")
+	if field != "" && !slices.Contains(store.GroupFields, field) {
+		app.badRequestResponse(w, r, fmt.Errorf("unknown group field %q", field))
+		return
+	}
+
+	beforeID := 0
+	if value := query.Get("before"); value != "" {
+		var err error
+		beforeID, err = strconv.Atoi(value)
+		if err != nil || beforeID < 1 {
+			app.badRequestResponse(w, r, errors.New("before must be a change id"))
+			return
+		}
+	}
+
+	limit := defaultGroupChangesLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxGroupChangesLimit {
+			app.badRequestResponse(w, r, fmt.Errorf("limit must be between 1 and %d", maxGroupChangesLimit))
+			return
+		}
+	}
+
+	changes, err := app.store.GroupRepository.GetGroupChanges(r.Context(), groupID, field, beforeID, limit)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Group changes fetched successfully", changes)
+}
+
 func (app *application) GetAllGroups(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
@@ -197,6 +343,8 @@ func (app *application) GetAllGroups(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	app.setCoverURLs(groups)
+
 	app.writeJSON(w, http.StatusOK, "All groups fetched successfully", groups)
 }
 
@@ -219,14 +367,14 @@ func (app *application) GetGroupMembers(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	members, err := app.store.GroupMembership.GetGroupMembers(ctx, group.ID)
+	user := r.Context().Value(userCtx).(store.User)
+
+	members, err := app.store.GroupMembership.GetGroupMembers(ctx, group.ID, user.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	user := r.Context().Value(userCtx).(store.User)
-
 	// Check if the user is allowed to see this group
 	isMember, err := app.store.GroupMembership.IsMember(ctx, group.ID, user.ID)
 	if err != nil {
@@ -257,7 +405,14 @@ func (app *application) JoinGroup(w http.ResponseWriter, r *http.Request) {
 
 	err = app.store.GroupJoinRequests.JoinRequest(ctx, groupIDInt, user.ID)
 	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("group not found"))
+		case errors.Is(err, store.ErrBanned):
+			app.forbiddenResponse(w, r, errors.New("you are banned from this group"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -265,29 +420,13 @@ func (app *application) JoinGroup(w http.ResponseWriter, r *http.Request) {
 }
 
 func (app *application) GetJoinRequests(w http.ResponseWriter, r *http.Request) {
-	groupID := chi.URLParam(r, "id")
+	groupIDInt := r.Context().Value(groupCtx).(groupAccess).ID
 
 	ctx := r.Context()
 
-	groupIDInt, err := strconv.Atoi(groupID)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
-	// Check if the user is the admin of the group
 	user := r.Context().Value(userCtx).(store.User)
-	isAdmin, err := app.store.GroupMembership.IsAdmin(ctx, groupIDInt, user.ID)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
-		return
-	}
-	if !isAdmin {
-		app.writeJSON(w, http.StatusForbidden, "not allowed", nil)
-		return
-	}
 
-	joinRequests, err := app.store.GroupJoinRequests.GetJoinRequests(ctx, groupIDInt)
+	joinRequests, err := app.store.GroupJoinRequests.GetJoinRequests(ctx, groupIDInt, user.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
@@ -302,7 +441,7 @@ type ApproveJoinRequestRequest struct {
 }
 
 func (app *application) ApproveJoinRequest(w http.ResponseWriter, r *http.Request) {
-	groupID := chi.URLParam(r, "id")
+	groupIDInt := r.Context().Value(groupCtx).(groupAccess).ID
 
 	var payload ApproveJoinRequestRequest
 	err := app.readJSON(r, &payload)
@@ -311,31 +450,21 @@ func (app *application) ApproveJoinRequest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	groupIDInt, err := strconv.Atoi(groupID)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
 	ctx := r.Context()
 
-	user := r.Context().Value(userCtx).(store.User)
-
-	// Check if the user is the admin of the group, is he allowed to approve/reject?
-	isAdmin, err := app.store.GroupMembership.IsAdmin(ctx, groupIDInt, user.ID)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
-		return
-	}
-	if !isAdmin {
-		app.writeJSON(w, http.StatusForbidden, "not allowed", nil)
-		return
-	}
-
 	if payload.Approve {
-		err = app.store.GroupJoinRequests.ApproveJoinRequest(ctx, groupIDInt, payload.UserID)
+		waitlisted, err := app.store.GroupJoinRequests.ApproveJoinRequest(ctx, groupIDInt, payload.UserID)
 		if err != nil {
-			app.internalServerErrorResponse(w, r, err)
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, errors.New("no pending join request from this user"))
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+		if waitlisted {
+			app.writeJSON(w, http.StatusOK, "Join request approved, the group is full so the user was added to the waitlist", nil)
 			return
 		}
 	} else {
@@ -364,7 +493,14 @@ func (app *application) LeaveGroup(w http.ResponseWriter, r *http.Request) {
 
 	err = app.store.GroupMembershipManagement.LeaveGroup(ctx, groupIDInt, user.ID)
 	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("you are not a member of this group"))
+		case errors.Is(err, store.ErrConflict):
+			app.errorJSON(w, errors.New("the owner must transfer or delete the group before leaving"), http.StatusConflict)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -387,9 +523,13 @@ func (app *application) GetJoinedGroups(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	app.setCoverURLs(groups)
+
 	app.writeJSON(w, http.StatusOK, "Joined groups fetched successfully", groups)
 }
 
+// IsAdmin reports whether the signed in user helps run the group, as owner or
+// moderator
 func (app *application) IsAdmin(w http.ResponseWriter, r *http.Request) {
 	groupID := chi.URLParam(r, "id")
 
@@ -403,22 +543,47 @@ func (app *application) IsAdmin(w http.ResponseWriter, r *http.Request) {
 
 	user := r.Context().Value(userCtx).(store.User)
 
-	isAdmin, err := app.store.GroupMembership.IsAdmin(ctx, groupIDInt, user.ID)
+	role, err := app.store.GroupMembership.GetRole(ctx, groupIDInt, user.ID)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
 
-	app.writeJSON(w, http.StatusOK, "Is admin", isAdmin)
+	app.writeJSON(w, http.StatusOK, "Is admin", store.IsManager(role))
 }
 
-// Invite user to group
+// GetGroupRole tells the signed in user their role in the group and what it
+// lets them do there. Non-members get an empty role.
+func (app *application) GetGroupRole(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := r.Context().Value(userCtx).(store.User)
+
+	role, err := app.store.GroupMembership.GetRole(r.Context(), groupID, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "Role fetched successfully", map[string]any{
+		"role":        role,
+		"permissions": store.RolePermissions(role),
+	})
+}
+
+// Invite user to group, by email or by the id of a user found through the
+// user search
 type InviteUserToGroupRequest struct {
-	Email string `json:"email"`
+	Email  string `json:"email"`
+	UserID int    `json:"user_id"`
 }
 
 func (app *application) InviteUserToGroup(w http.ResponseWriter, r *http.Request) {
-	groupID := chi.URLParam(r, "id")
+	groupIDInt := r.Context().Value(groupCtx).(groupAccess).ID
 
 	var payload InviteUserToGroupRequest
 	err := app.readJSON(r, &payload)
@@ -427,33 +592,32 @@ func (app *application) InviteUserToGroup(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	groupIDInt, err := strconv.Atoi(groupID)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
 	ctx := r.Context()
 
 	// Get user id from context
 	user := r.Context().Value(userCtx).(store.User)
 
-	// Check if user is admin
-	isAdmin, err := app.store.GroupMembership.IsAdmin(ctx, groupIDInt, user.ID)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
-		return
-	}
-	if !isAdmin {
-		app.writeJSON(w, http.StatusForbidden, "not allowed", nil)
+	if (payload.Email == "") == (payload.UserID == 0) {
+		app.badRequestResponse(w, r, errors.New("either an email or a user id is required"))
 		return
 	}
 
 	// Check if user exists
-	invitedUser, err := app.store.User.GetUserByEmail(ctx, payload.Email)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
+	var invitedUser store.UserData
+	if payload.UserID != 0 {
+		// Users can only be invited by id when the inviter may see their profile
+		profile, err := app.store.User.GetProfile(ctx, payload.UserID)
+		if err != nil || !profile.VisibleTo(user) {
+			app.badRequestResponse(w, r, errors.New("user not found"))
+			return
+		}
+		invitedUser.ID = profile.ID
+	} else {
+		invitedUser, err = app.store.User.GetUserByEmail(ctx, payload.Email)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
 	}
 
 	// Check if user is already a member
@@ -468,9 +632,16 @@ func (app *application) InviteUserToGroup(w http.ResponseWriter, r *http.Request
 	}
 
 	// Invite user
-	err = app.store.GroupInvitations.InviteUserToGroup(ctx, groupIDInt, invitedUser.ID)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
+	err = app.store.GroupInvitations.InviteUserToGroup(ctx, groupIDInt, invitedUser.ID, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrBlocked):
+			app.forbiddenResponse(w, r, errors.New("this user cannot be invited"))
+		case errors.Is(err, store.ErrBanned):
+			app.errorJSON(w, errors.New("this user is banned from the group"), http.StatusConflict)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
@@ -528,11 +699,15 @@ func (app *application) AcceptInvitation(w http.ResponseWriter, r *http.Request)
 	ctx := r.Context()
 	user := r.Context().Value(userCtx).(store.User)
 
-	err = app.store.GroupInvitations.AcceptInvitation(ctx, user.ID, groupIDInt)
+	waitlisted, err := app.store.GroupInvitations.AcceptInvitation(ctx, user.ID, groupIDInt)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
+	if waitlisted {
+		app.writeJSON(w, http.StatusOK, "Invitation accepted, the group is full so you were added to the waitlist", nil)
+		return
+	}
 
 	app.writeJSON(w, http.StatusOK, "Invitation accepted successfully", nil)
 }
@@ -565,8 +740,9 @@ func (app *application) ResolveInvitation(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	waitlisted := false
 	if payload.Accept {
-		err = app.store.GroupInvitations.AcceptInvitation(ctx, user.ID, payload.GroupID)
+		waitlisted, err = app.store.GroupInvitations.AcceptInvitation(ctx, user.ID, payload.GroupID)
 	} else {
 		err = app.store.GroupInvitations.RejectInvitation(ctx, user.ID, payload.GroupID)
 	}
@@ -574,6 +750,10 @@ func (app *application) ResolveInvitation(w http.ResponseWriter, r *http.Request
 		app.internalServerErrorResponse(w, r, err)
 		return
 	}
+	if waitlisted {
+		app.writeJSON(w, http.StatusOK, "Invitation accepted, the group is full so you were added to the waitlist", nil)
+		return
+	}
 
 	action := "accepted"
 	if !payload.Accept {
@@ -585,33 +765,54 @@ func (app *application) ResolveInvitation(w http.ResponseWriter, r *http.Request
 
 // delete group
 func (app *application) DeleteGroup(w http.ResponseWriter, r *http.Request) {
-	groupID := chi.URLParam(r, "id")
-
-	groupIDInt, err := strconv.Atoi(groupID)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
+	groupIDInt := r.Context().Value(groupCtx).(groupAccess).ID
 
 	ctx := r.Context()
-	user := r.Context().Value(userCtx).(store.User)
 
-	// Check if user is admin
-	isAdmin, err := app.store.GroupMembership.IsAdmin(ctx, groupIDInt, user.ID)
+	coverKey, err := app.store.GroupRepository.DeleteGroup(ctx, groupIDInt)
 	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
-		return
-	}
-	if !isAdmin {
-		app.writeJSON(w, http.StatusForbidden, "not allowed", nil)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("group not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
 		return
 	}
 
-	err = app.store.GroupRepository.DeleteGroup(ctx, groupIDInt)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
-		return
-	}
+	app.deleteImage(ctx, coverKey)
 
 	app.writeJSON(w, http.StatusOK, "Group deleted successfully", nil)
 }
+
+const waitlistNotificationBatch = 100
+
+// notifyWaitlistPromotions emails the users who were let into a group from its
+// waitlist since the last run
+func (app *application) notifyWaitlistPromotions(ctx context.Context) error {
+	afterID := 0
+	for range waitlistNotificationBatch {
+		id, err := app.store.GroupJoinRequests.NotifyWaitlistPromotion(ctx, afterID, app.sendWaitlistNotice)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return nil
+		case id == 0:
+			return err
+		case err != nil:
+			// Kept for the next run
+			log.Printf("failed to notify waitlist promotion %d: %v", id, err)
+		}
+
+		afterID = id
+	}
+
+	return nil
+}
+
+func (app *application) sendWaitlistNotice(promotion store.WaitlistPromotion) error {
+	return app.mailer.Send(mailer.WaitlistJoinedTemplate, promotion.Email, map[string]string{
+		"FirstName": promotion.FirstName,
+		"GroupName": promotion.GroupName,
+		"GroupURL":  fmt.Sprintf("%s/groups/%d", app.config.frontendURL, promotion.GroupID),
+	})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// startJobs schedules the periodic maintenance tasks
+func (app *application) startJobs() {
+	app.runPeriodically("prune login attempts", time.Hour, func(ctx context.Context) error {
+		return app.store.LoginAttempts.DeleteAttemptsBefore(ctx, time.Now().Add(-24*time.Hour))
+	})
+
+	app.runPeriodically("delete accounts", time.Hour, app.deleteDueAccounts)
+
+	app.runPeriodically("notify waitlist promotions", time.Minute, app.notifyWaitlistPromotions)
+}
+
+// runPeriodically calls fn every interval in the background until the process exits
+func (app *application) runPeriodically(name string, interval time.Duration, fn func(ctx context.Context) error) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			err := fn(ctx)
+			cancel()
+
+			if err != nil {
+				log.Printf("job %q failed: %v", name, err)
+			}
+		}
+	}()
+}
+
+// background runs fn after the response has been sent, for work such as email
+// that should not keep the client waiting on a slow server
+func (app *application) background(name string, fn func() error) {
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				log.Printf("background task %q panicked: %v", name, p)
+			}
+		}()
+
+		err := fn()
+		if err != nil {
+			log.Printf("background task %q failed: %v", name, err)
+		}
+	}()
+}
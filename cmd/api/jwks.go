@@ -0,0 +1,21 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// GetJWKS serves the verification keys as a bare RFC 7517 key set, not wrapped
+// in the usual response envelope, so standard JWT libraries can consume it
+func (app *application) GetJWKS(w http.ResponseWriter, r *http.Request) {
+	js, err := json.Marshal(app.keys.JWKS())
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	w.WriteHeader(http.StatusOK)
+	w.Write(js)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+)
+
+// At most magicLinkLimit links are emailed to an address per magicLinkWindow,
+// so the endpoint cannot be used to flood someone's inbox
+const (
+	magicLinkLimit  = 5
+	magicLinkWindow = time.Hour
+)
+
+type RequestMagicLinkRequest struct {
+	Email string `json:"email"`
+	// CodeChallenge is the base64url SHA-256 of a random verifier the browser
+	// keeps. It is optional, but a link requested with it can only be used by
+	// presenting the verifier.
+	CodeChallenge string `json:"code_challenge"`
+}
+
+// RequestMagicLink emails a one-time sign-in link. Like ForgotPassword it
+// responds the same way whether or not the account exists.
+func (app *application) RequestMagicLink(w http.ResponseWriter, r *http.Request) {
+	var payload RequestMagicLinkRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	// A SHA-256 digest is always 43 characters of unpadded base64url
+	if payload.CodeChallenge != "" && len(payload.CodeChallenge) != 43 {
+		app.badRequestResponse(w, r, errors.New("invalid code challenge"))
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNoRows) {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err == nil {
+		sent, err := app.store.MagicLinks.CountMagicLinksSince(ctx, user.ID, time.Now().Add(-magicLinkWindow))
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		if sent < magicLinkLimit {
+			token, err := app.store.MagicLinks.CreateMagicLink(ctx, user.ID, payload.CodeChallenge, time.Now().Add(app.config.auth.magicLinkExp))
+			if err != nil {
+				app.internalServerErrorResponse(w, r, err)
+				return
+			}
+
+			// Sent after responding, like the password reset email
+			app.background(fmt.Sprintf("sign-in link for user %d", user.ID), func() error {
+				return app.mailer.Send(mailer.MagicLinkTemplate, user.Email, map[string]string{
+					"FirstName": user.FirstName,
+					"LoginURL":  fmt.Sprintf("%s/magic-link?token=%s", app.config.frontendURL, url.QueryEscape(token)),
+					"ExpiresIn": fmt.Sprintf("%d minutes", int(app.config.auth.magicLinkExp.Minutes())),
+				})
+			})
+		}
+	}
+
+	app.writeJSON(w, http.StatusOK, "if an account exists for this email, a sign-in link has been sent", nil)
+}
+
+type ConsumeMagicLinkRequest struct {
+	Token        string `json:"token"`
+	CodeVerifier string `json:"code_verifier"`
+}
+
+// ConsumeMagicLink signs the user in with a link token, issuing tokens the same
+// way Login does
+func (app *application) ConsumeMagicLink(w http.ResponseWriter, r *http.Request) {
+	var payload ConsumeMagicLinkRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Token == "" {
+		app.badRequestResponse(w, r, errors.New("sign-in token is required"))
+		return
+	}
+
+	userID, err := app.store.MagicLinks.ConsumeMagicLink(r.Context(), payload.Token, payload.CodeVerifier)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.badRequestResponse(w, r, errors.New("invalid or expired sign-in link"))
+		case errors.Is(err, store.ErrLinkBindingMismatch):
+			app.forbiddenResponse(w, r, errors.New("open the sign-in link in the browser you requested it from"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.completeLogin(w, r, userID)
+}
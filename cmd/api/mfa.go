@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/RakibulBh/studygroup-backend/internal/totp"
+)
+
+const (
+	totpIssuer         = "StudyGroup"
+	mfaChallengeAction = "mfa_challenge"
+	recoveryCodeCount  = 10
+)
+
+// EnrollTOTP starts 2FA enrollment. The secret only takes effect once it is
+// confirmed with a code from the authenticator app.
+func (app *application) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	secret := totp.GenerateSecret()
+
+	err := app.store.MFA.EnrollTOTP(r.Context(), user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "scan the code with your authenticator app and confirm it", map[string]string{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	})
+}
+
+type ConfirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// ConfirmTOTP enables 2FA and returns the recovery codes. They are only ever
+// shown once.
+func (app *application) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload ConfirmTOTPRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	secret, err := app.store.MFA.GetTOTP(ctx, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.badRequestResponse(w, r, errors.New("two-factor authentication enrollment has not been started"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if secret.Confirmed {
+		app.badRequestResponse(w, r, errors.New("two-factor authentication is already enabled"))
+		return
+	}
+
+	ok, err := app.useTOTPCode(ctx, secret, payload.Code)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("invalid code"))
+		return
+	}
+
+	recoveryCodes := generateRecoveryCodes()
+
+	err = app.store.MFA.ConfirmTOTP(ctx, user.ID, normalizeRecoveryCodes(recoveryCodes))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "two-factor authentication enabled", map[string]any{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+type SecondFactorRequest struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload SecondFactorRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	ok, err := app.verifySecondFactor(ctx, user.ID, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("invalid code"))
+		return
+	}
+
+	recoveryCodes := generateRecoveryCodes()
+
+	err = app.store.MFA.ReplaceRecoveryCodes(ctx, user.ID, normalizeRecoveryCodes(recoveryCodes))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "recovery codes regenerated", map[string]any{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+type DisableTOTPRequest struct {
+	Password     string `json:"password"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// DisableTOTP requires both the password and a second factor
+func (app *application) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload DisableTOTPRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userData, err := app.store.User.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	passwordMatches, err := app.store.Auth.VerifyPassword(payload.Password, userData.PasswordHash)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if !passwordMatches {
+		app.badRequestResponse(w, r, errors.New("invalid credentials"))
+		return
+	}
+
+	ok, err := app.verifySecondFactor(ctx, user.ID, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		app.badRequestResponse(w, r, errors.New("invalid code"))
+		return
+	}
+
+	err = app.store.MFA.DisableTOTP(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "two-factor authentication disabled", nil)
+}
+
+type VerifyMFARequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// VerifyMFA is the second step of Login for users with 2FA enabled. It trades
+// the challenge token and a code for the usual access/refresh pair.
+func (app *application) VerifyMFA(w http.ResponseWriter, r *http.Request) {
+	var payload VerifyMFARequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	userID, err := app.store.Auth.VerifyActionToken(payload.MFAToken, mfaChallengeAction, app.keys)
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("invalid or expired challenge"))
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.User.GetUserByID(ctx, userID)
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("invalid or expired challenge"))
+		return
+	}
+
+	// Second factor guesses count towards the same lockout as passwords
+	email := normalizeEmail(user.Email)
+	ip := clientIP(r)
+
+	attempt, retryAfter, err := app.beginLoginAttempt(ctx, email, ip)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if retryAfter > 0 {
+		app.tooManyRequestsResponse(w, r, retryAfter)
+		return
+	}
+
+	ok, err := app.verifySecondFactor(ctx, userID, payload.Code, payload.RecoveryCode)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.recordLoginFailure(ctx, attempt, email, ip)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		app.unauthorizedResponse(w, r, errors.New("invalid code"))
+		return
+	}
+
+	err = app.store.LoginAttempts.SucceedAttempt(ctx, attempt.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	accessToken, refreshToken, err := app.issueTokens(r, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeTokens(w, r, http.StatusAccepted, "logged in successfully", accessToken, refreshToken)
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code
+func (app *application) verifySecondFactor(ctx context.Context, userID int, code string, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return app.store.MFA.UseRecoveryCode(ctx, userID, normalizeRecoveryCode(recoveryCode))
+	}
+
+	secret, err := app.store.MFA.GetTOTP(ctx, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	if !secret.Confirmed {
+		return false, nil
+	}
+
+	return app.useTOTPCode(ctx, secret, code)
+}
+
+// useTOTPCode validates a code and burns its time step so it cannot be replayed
+func (app *application) useTOTPCode(ctx context.Context, secret store.TOTP, code string) (bool, error) {
+	step, ok := totp.Validate(secret.Secret, code, time.Now())
+	if !ok || step <= secret.LastUsedStep {
+		return false, nil
+	}
+
+	return app.store.MFA.UseStep(ctx, secret.UserID, step)
+}
+
+// generateRecoveryCodes returns codes formatted as XXXXX-XXXXX for readability
+func generateRecoveryCodes() []string {
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		code := rand.Text()[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes
+}
+
+func normalizeRecoveryCodes(codes []string) []string {
+	normalized := make([]string, len(codes))
+	for i, code := range codes {
+		normalized[i] = normalizeRecoveryCode(code)
+	}
+
+	return normalized
+}
+
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
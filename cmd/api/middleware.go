@@ -4,10 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
 	"github.com/golang-jwt/jwt/v5"
 )
 
@@ -15,7 +18,10 @@ import (
 type contextKey string
 
 const (
-	userCtx contextKey = "user"
+	userCtx    contextKey = "user"
+	sessionCtx contextKey = "session"
+	apiKeyCtx  contextKey = "api_key"
+	groupCtx   contextKey = "group"
 )
 
 // Authentication middleware
@@ -37,16 +43,22 @@ func (app *application) Authenticate(next http.Handler) http.Handler {
 
 		token := parts[1]
 
+		if strings.HasPrefix(token, store.APIKeyPrefix) {
+			app.authenticateAPIKey(w, r, next, token)
+			return
+		}
+
 		// Validate if the token is valid
-		jwtToken, err := app.store.Auth.VerifyToken(token, app.config.auth.jwtSecret)
+		jwtToken, err := app.store.Auth.VerifyToken(token, app.keys)
 		if err != nil {
 			app.unauthorizedResponse(w, r, errors.New("invalid token"))
 			return
 		}
 
-		// Get the user from the token
+		// Get the user from the token, which must be an access token and not
+		// a refresh token
 		claims, ok := jwtToken.Claims.(jwt.MapClaims)
-		if !ok {
+		if !ok || claims["typ"] != store.TokenTypeAccess {
 			app.unauthorizedResponse(w, r, errors.New("invalid token"))
 			return
 		}
@@ -56,8 +68,27 @@ func (app *application) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		sessionID, ok := claims["sid"].(string)
+		if !ok || sessionID == "" {
+			app.unauthorizedResponse(w, r, errors.New("invalid token"))
+			return
+		}
+
 		ctx := r.Context()
 
+		// Reject tokens of signed out sessions or issued before the user last
+		// logged out everywhere
+		issuedAt, err := claims.GetIssuedAt()
+		if err != nil || issuedAt == nil {
+			app.unauthorizedResponse(w, r, errors.New("invalid token"))
+			return
+		}
+		revoked, err := app.store.Auth.IsTokenRevoked(ctx, int(userID), sessionID, issuedAt.Time)
+		if err != nil || revoked {
+			app.unauthorizedResponse(w, r, errors.New("token has been revoked"))
+			return
+		}
+
 		// Fetch the user
 		user, err := app.store.User.GetUserByID(ctx, int(userID))
 		if err != nil {
@@ -65,9 +96,156 @@ func (app *application) Authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		// Activity tracking is best effort and must not fail the request
+		err = app.store.Auth.TouchSession(ctx, sessionID, requestDevice(r))
+		if err != nil {
+			log.Printf("failed to update session %s activity: %v", sessionID, err)
+		}
+
 		ctx = context.WithValue(ctx, userCtx, user)
+		ctx = context.WithValue(ctx, sessionCtx, sessionID)
 
 		// If all checks pass the user is allowed to go through
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// authenticateAPIKey is the Authenticate path for personal API keys. Which
+// routes a key may reach is decided by RequireScope and RejectAPIKeys.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, next http.Handler, key string) {
+	ctx := r.Context()
+
+	apiKey, err := app.store.APIKeys.GetAPIKey(ctx, key)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.unauthorizedResponse(w, r, errors.New("invalid or expired api key"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.store.User.GetUserByID(ctx, apiKey.UserID)
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("invalid user"))
+		return
+	}
+
+	err = app.store.APIKeys.TouchAPIKey(ctx, apiKey.ID)
+	if err != nil {
+		log.Printf("failed to update api key %d last used time: %v", apiKey.ID, err)
+	}
+
+	ctx = context.WithValue(ctx, userCtx, user)
+	ctx = context.WithValue(ctx, apiKeyCtx, apiKey)
+
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// RequireScope limits API key requests to keys holding readScope for safe
+// methods and writeScope for everything else. Requests made with a JWT carry
+// the user's full access and pass through. It must run after Authenticate.
+func (app *application) RequireScope(readScope string, writeScope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			apiKey, ok := r.Context().Value(apiKeyCtx).(store.APIKey)
+			if ok {
+				scope := writeScope
+				if r.Method == http.MethodGet || r.Method == http.MethodHead {
+					scope = readScope
+				}
+
+				if !apiKey.HasScope(scope) {
+					app.forbiddenResponse(w, r, fmt.Errorf("api key is missing the %s scope", scope))
+					return
+				}
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RejectAPIKeys keeps API keys away from account management routes such as
+// passwords, 2FA and minting more keys. It must run after Authenticate.
+func (app *application) RejectAPIKeys(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := r.Context().Value(apiKeyCtx).(store.APIKey)
+		if ok {
+			app.forbiddenResponse(w, r, errors.New("this endpoint cannot be used with an api key"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireVerifiedEmail restricts a route to users who have verified their email
+// address. It must run after Authenticate.
+func (app *application) RequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(userCtx).(store.User)
+		if !user.EmailVerified {
+			app.forbiddenResponse(w, r, errors.New("email address is not verified"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireSiteAdmin restricts a route to site administrators. It must run after
+// Authenticate.
+func (app *application) RequireSiteAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Context().Value(userCtx).(store.User)
+		if !user.SiteAdmin {
+			app.forbiddenResponse(w, r, errors.New("site administrators only"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// groupAccess is the group a route acts on and the signed in user's role in it,
+// as established by RequireGroupPermission
+type groupAccess struct {
+	ID   int
+	Role string
+}
+
+// RequireGroupPermission restricts a group route to members whose role grants
+// the permission. The group id is read from the {id} or {groupID} path
+// parameter. It must run after Authenticate.
+func (app *application) RequireGroupPermission(permission store.Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			param := chi.URLParam(r, "id")
+			if param == "" {
+				param = chi.URLParam(r, "groupID")
+			}
+			groupID, err := strconv.Atoi(param)
+			if err != nil {
+				app.badRequestResponse(w, r, errors.New("invalid group id"))
+				return
+			}
+
+			user := r.Context().Value(userCtx).(store.User)
+
+			role, err := app.store.GroupMembership.GetRole(r.Context(), groupID, user.ID)
+			if err != nil {
+				app.internalServerErrorResponse(w, r, err)
+				return
+			}
+			if !store.RoleCan(role, permission) {
+				app.forbiddenResponse(w, r, fmt.Errorf("your role in this group does not allow %s", strings.ReplaceAll(string(permission), "_", " ")))
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), groupCtx, groupAccess{ID: groupID, Role: role})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
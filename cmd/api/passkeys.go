@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const maxPasskeyNameLength = 100
+
+// passkeyUser adapts a user and their registered credentials to webauthn.User
+type passkeyUser struct {
+	user     store.User
+	passkeys []store.Passkey
+}
+
+func (u passkeyUser) WebAuthnID() []byte {
+	return passkeyUserHandle(u.user.ID)
+}
+
+func (u passkeyUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u passkeyUser) WebAuthnDisplayName() string {
+	return u.user.FirstName + " " + u.user.LastName
+}
+
+func (u passkeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.passkeys))
+	for i, passkey := range u.passkeys {
+		credentials[i] = passkey.Credential
+	}
+
+	return credentials
+}
+
+// passkeyUserHandle is the WebAuthn user handle of a user, the big endian
+// bytes of their ID. Authenticators hand it back on discoverable logins.
+func passkeyUserHandle(userID int) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(userID))
+}
+
+func (app *application) loadPasskeyUser(r *http.Request, userID int) (passkeyUser, error) {
+	user, err := app.store.User.GetUserByID(r.Context(), userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	passkeys, err := app.store.Passkeys.GetPasskeys(r.Context(), userID)
+	if err != nil {
+		return passkeyUser{}, err
+	}
+
+	return passkeyUser{user: user, passkeys: passkeys}, nil
+}
+
+// BeginPasskeyRegistration starts registering a passkey for the signed in user.
+// The options are passed to navigator.credentials.create() in the browser.
+func (app *application) BeginPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	account, err := app.loadPasskeyUser(r, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// Stop the same authenticator from being registered twice
+	exclusions := webauthn.Credentials(account.WebAuthnCredentials()).CredentialDescriptors()
+
+	options, session, err := app.webauthn.BeginRegistration(account, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	ceremonyID, err := app.store.Passkeys.CreateCeremony(r.Context(), store.CeremonyRegistration, user.ID, *session, time.Now().Add(app.config.auth.passkeyExp))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "passkey registration started", map[string]any{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+type FinishPasskeyRegistrationRequest struct {
+	CeremonyID string `json:"ceremony_id"`
+	Name       string `json:"name"`
+	// Credential is the PublicKeyCredential returned by the browser
+	Credential json.RawMessage `json:"credential"`
+}
+
+func (app *application) FinishPasskeyRegistration(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload FinishPasskeyRegistrationRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if len(payload.Name) == 0 || len(payload.Name) > maxPasskeyNameLength {
+		app.badRequestResponse(w, r, fmt.Errorf("name must be between 1 and %d characters", maxPasskeyNameLength))
+		return
+	}
+
+	ctx := r.Context()
+
+	session, err := app.store.Passkeys.ConsumeCeremony(ctx, payload.CeremonyID, store.CeremonyRegistration, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.badRequestResponse(w, r, errors.New("passkey registration has expired, please try again"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response, err := protocol.ParseCredentialCreationResponseBytes(payload.Credential)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid passkey credential"))
+		return
+	}
+
+	account, err := app.loadPasskeyUser(r, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	credential, err := app.webauthn.CreateCredential(account, session, response)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("passkey could not be verified"))
+		return
+	}
+
+	passkey, err := app.store.Passkeys.AddPasskey(ctx, user.ID, payload.Name, *credential)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.badRequestResponse(w, r, errors.New("this passkey is already registered"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, "passkey registered successfully", passkey)
+}
+
+func (app *application) GetPasskeys(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	passkeys, err := app.store.Passkeys.GetPasskeys(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "passkeys fetched successfully", passkeys)
+}
+
+func (app *application) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	passkeyID, err := strconv.Atoi(chi.URLParam(r, "passkeyID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid passkey id"))
+		return
+	}
+
+	err = app.store.Passkeys.DeletePasskey(r.Context(), user.ID, passkeyID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("passkey not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "passkey deleted successfully", nil)
+}
+
+// BeginPasskeyLogin starts a discoverable login, the browser lets the user pick
+// any passkey they have for this site. The options are passed to
+// navigator.credentials.get().
+func (app *application) BeginPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	options, session, err := app.webauthn.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	ceremonyID, err := app.store.Passkeys.CreateCeremony(r.Context(), store.CeremonyLogin, 0, *session, time.Now().Add(app.config.auth.passkeyExp))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "passkey login started", map[string]any{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+type FinishPasskeyLoginRequest struct {
+	CeremonyID string `json:"ceremony_id"`
+	// Credential is the PublicKeyCredential returned by the browser
+	Credential json.RawMessage `json:"credential"`
+}
+
+// FinishPasskeyLogin verifies the assertion and signs the user in the same way
+// Login does. A passkey that looks cloned is refused.
+func (app *application) FinishPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	var payload FinishPasskeyLoginRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	session, err := app.store.Passkeys.ConsumeCeremony(ctx, payload.CeremonyID, store.CeremonyLogin, 0)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.badRequestResponse(w, r, errors.New("passkey login has expired, please try again"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	response, err := protocol.ParseCredentialRequestResponseBytes(payload.Credential)
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid passkey credential"))
+		return
+	}
+
+	var account passkeyUser
+	credential, err := app.webauthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 8 {
+			return nil, errors.New("unknown user handle")
+		}
+
+		account, err = app.loadPasskeyUser(r, int(binary.BigEndian.Uint64(userHandle)))
+		return account, err
+	}, session, response)
+	if err != nil {
+		app.unauthorizedResponse(w, r, errors.New("passkey could not be verified"))
+		return
+	}
+
+	// A sign count that did not go up means another copy of the key has been
+	// used, so the login is refused rather than trusting either copy. The
+	// stored count is left as it was, the owner removes the passkey and
+	// registers it again.
+	if credential.Authenticator.CloneWarning {
+		log.Printf("security: passkey sign count went backwards for user %d, the authenticator may be cloned", account.user.ID)
+		app.unauthorizedResponse(w, r, errors.New("this passkey may have been copied, sign in another way and register it again"))
+		return
+	}
+
+	err = app.store.Passkeys.UsePasskey(ctx, *credential)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.completeLogin(w, r, account.user.ID)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID     = "localhost"
+	testRPOrigin = "http://localhost:3000"
+)
+
+func newTestPasskeyApplication(t *testing.T) *application {
+	t.Helper()
+
+	app := newTestApplication(t)
+
+	relyingParty, err := webauthn.New(&webauthn.Config{
+		RPID:          testRPID,
+		RPDisplayName: totpIssuer,
+		RPOrigins:     []string{testRPOrigin},
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.webauthn = relyingParty
+
+	return app
+}
+
+// softAuthenticator is a passkey held in memory, it answers ceremonies the way
+// a browser would hand them back with "none" attestation
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &softAuthenticator{key: key, credentialID: []byte(rand.Text())}
+}
+
+var b64 = base64.RawURLEncoding
+
+func clientData(t *testing.T, ceremony protocol.CeremonyType, challenge protocol.URLEncodedBase64) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(protocol.CollectedClientData{
+		Type:      ceremony,
+		Challenge: challenge.String(),
+		Origin:    testRPOrigin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+// authenticatorData is the RP ID hash, the flags and the sign count, followed
+// by attested credential data on registration
+func (a *softAuthenticator) authenticatorData(flags protocol.AuthenticatorFlags, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(testRPID))
+
+	data := append(rpIDHash[:], byte(flags|protocol.FlagUserPresent|protocol.FlagUserVerified))
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	return append(data, attested...)
+}
+
+// create answers navigator.credentials.create()
+func (a *softAuthenticator) create(t *testing.T, options protocol.CredentialCreation) json.RawMessage {
+	t.Helper()
+
+	userID, _ := options.Response.User.ID.(string)
+	userHandle, err := b64.DecodeString(userID)
+	if err != nil {
+		t.Fatalf("decoding user handle: %v", err)
+	}
+	a.userHandle = userHandle
+
+	publicKey, err := webauthncbor.Marshal(map[int]any{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authenticatorData(protocol.FlagAttestedCredentialData, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64.EncodeToString(clientData(t, protocol.CreateCeremony, options.Response.Challenge)),
+		"attestationObject": b64.EncodeToString(attestation),
+	})
+}
+
+// get answers navigator.credentials.get(), counting the use like a hardware
+// key does
+func (a *softAuthenticator) get(t *testing.T, options protocol.CredentialAssertion) json.RawMessage {
+	t.Helper()
+
+	a.signCount++
+
+	authData := a.authenticatorData(0, nil)
+	clientDataJSON := clientData(t, protocol.AssertCeremony, options.Response.Challenge)
+	clientDataHash := sha256.Sum256(clientDataJSON)
+
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    b64.EncodeToString(clientDataJSON),
+		"authenticatorData": b64.EncodeToString(authData),
+		"signature":         b64.EncodeToString(signature),
+		"userHandle":        b64.EncodeToString(a.userHandle),
+	})
+}
+
+func (a *softAuthenticator) credential(t *testing.T, response map[string]string) json.RawMessage {
+	t.Helper()
+
+	credential, err := json.Marshal(map[string]any{
+		"id":       b64.EncodeToString(a.credentialID),
+		"rawId":    b64.EncodeToString(a.credentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credential
+}
+
+func (app *application) registerPasskey(t *testing.T, accessToken string, authenticator *softAuthenticator) {
+	t.Helper()
+
+	w := app.serve(t, http.MethodPost, "/v1/auth/passkeys/register/begin", nil, withAccessToken(accessToken))
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin registration: got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var started struct {
+		CeremonyID string                      `json:"ceremony_id"`
+		Options    protocol.CredentialCreation `json:"options"`
+	}
+	decodeData(t, w, &started)
+
+	w = app.serve(t, http.MethodPost, "/v1/auth/passkeys/register/finish", FinishPasskeyRegistrationRequest{
+		CeremonyID: started.CeremonyID,
+		Name:       "Test key",
+		Credential: authenticator.create(t, started.Options),
+	}, withAccessToken(accessToken))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("finish registration: got status %d: %s", w.Code, w.Body.String())
+	}
+}
+
+// passkeyLogin signs in with the authenticator, returning the status and the
+// access token on success
+func (app *application) passkeyLogin(t *testing.T, authenticator *softAuthenticator) (int, string) {
+	t.Helper()
+
+	w := app.serve(t, http.MethodPost, "/v1/auth/passkeys/login/begin", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin login: got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var started struct {
+		CeremonyID string                       `json:"ceremony_id"`
+		Options    protocol.CredentialAssertion `json:"options"`
+	}
+	decodeData(t, w, &started)
+
+	w = app.serve(t, http.MethodPost, "/v1/auth/passkeys/login/finish", FinishPasskeyLoginRequest{
+		CeremonyID: started.CeremonyID,
+		Credential: authenticator.get(t, started.Options),
+	})
+	if w.Code != http.StatusAccepted {
+		return w.Code, ""
+	}
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+	}
+	decodeData(t, w, &data)
+
+	return w.Code, data.AccessToken
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	app := newTestPasskeyApplication(t)
+
+	userID := app.createUser(t, testdb.Email(), "correct horse battery staple", true)
+	authenticator := newSoftAuthenticator(t)
+	app.registerPasskey(t, app.login(t, userID), authenticator)
+
+	// Twice, the second login must accept the raised sign count
+	for range 2 {
+		status, accessToken := app.passkeyLogin(t, authenticator)
+		if status != http.StatusAccepted {
+			t.Fatalf("got status %d, want %d", status, http.StatusAccepted)
+		}
+		if got := app.tokenUserID(t, accessToken); got != userID {
+			t.Fatalf("logged in as user %d, want %d", got, userID)
+		}
+	}
+
+	passkeys, err := app.store.Passkeys.GetPasskeys(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Credential.Authenticator.SignCount != authenticator.signCount {
+		t.Fatalf("got passkeys %+v, want one with sign count %d", passkeys, authenticator.signCount)
+	}
+}
+
+func TestPasskeyLoginRejectsSignCountRegression(t *testing.T) {
+	app := newTestPasskeyApplication(t)
+
+	userID := app.createUser(t, testdb.Email(), "correct horse battery staple", true)
+	authenticator := newSoftAuthenticator(t)
+	app.registerPasskey(t, app.login(t, userID), authenticator)
+
+	// A copy of the key taken now lags behind once the original is used again
+	clone := *authenticator
+
+	for range 2 {
+		status, _ := app.passkeyLogin(t, authenticator)
+		if status != http.StatusAccepted {
+			t.Fatalf("got status %d, want %d", status, http.StatusAccepted)
+		}
+	}
+
+	status, _ := app.passkeyLogin(t, &clone)
+	if status != http.StatusUnauthorized {
+		t.Fatalf("login with a lagging sign count: got status %d, want %d", status, http.StatusUnauthorized)
+	}
+
+	passkeys, err := app.store.Passkeys.GetPasskeys(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Credential.Authenticator.SignCount != authenticator.signCount {
+		t.Fatalf("got passkeys %+v, want the sign count left at %d", passkeys, authenticator.signCount)
+	}
+}
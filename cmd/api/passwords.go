@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/mailer"
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+)
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ForgotPassword emails a reset link. It responds the same way whether or not
+// the account exists so it cannot be used to discover registered emails.
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var payload ForgotPasswordRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	user, err := app.store.User.GetUserByEmail(ctx, payload.Email)
+	if err != nil && !errors.Is(err, store.ErrNoRows) {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if err == nil {
+		token, err := app.store.PasswordReset.CreateResetToken(ctx, user.ID, time.Now().Add(app.config.auth.resetExp))
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+
+		// Sent after responding, a slow mail server would otherwise give away
+		// that the account exists
+		app.background(fmt.Sprintf("password reset email for user %d", user.ID), func() error {
+			return app.mailer.Send(mailer.ResetPasswordTemplate, user.Email, map[string]string{
+				"FirstName": user.FirstName,
+				"ResetURL":  fmt.Sprintf("%s/reset-password?token=%s", app.config.frontendURL, url.QueryEscape(token)),
+				"ExpiresIn": fmt.Sprintf("%d minutes", int(app.config.auth.resetExp.Minutes())),
+			})
+		})
+	}
+
+	app.writeJSON(w, http.StatusOK, "if an account exists for this email, a reset link has been sent", nil)
+}
+
+type ResetPasswordRequest struct {
+	Token           string `json:"token"`
+	Password        string `json:"password"`
+	PasswordConfirm string `json:"password_confirm"`
+}
+
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var payload ResetPasswordRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if payload.Token == "" {
+		app.badRequestResponse(w, r, errors.New("reset token is required"))
+		return
+	}
+
+	err = validateNewPassword(payload.Password, payload.PasswordConfirm)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	hash, err := app.store.Auth.HashPassword(payload.Password)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	_, err = app.store.PasswordReset.ResetPassword(r.Context(), payload.Token, hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.badRequestResponse(w, r, errors.New("invalid or expired reset token"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "password reset successfully, please log in again", nil)
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+	PasswordConfirm string `json:"password_confirm"`
+}
+
+// ChangePassword requires the current password. Every session is signed out,
+// including the current one, so the response carries a fresh token pair.
+func (app *application) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload ChangePasswordRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	err = validateNewPassword(payload.Password, payload.PasswordConfirm)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	userData, err := app.store.User.GetUserByEmail(ctx, user.Email)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	passwordMatches, err := app.store.Auth.VerifyPassword(payload.CurrentPassword, userData.PasswordHash)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if !passwordMatches {
+		app.badRequestResponse(w, r, errors.New("current password is incorrect"))
+		return
+	}
+
+	hash, err := app.store.Auth.HashPassword(payload.Password)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.store.PasswordReset.ChangePassword(ctx, user.ID, hash)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	accessToken, refreshToken, err := app.issueTokens(r, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeTokens(w, r, http.StatusOK, "password changed successfully", accessToken, refreshToken)
+}
+
+// validateNewPassword applies the same rules as Register
+func validateNewPassword(password string, passwordConfirm string) error {
+	if len(password) < 8 {
+		return errors.New("password must be at least 8 characters")
+	}
+
+	if password != passwordConfirm {
+		return errors.New("password does not match")
+	}
+
+	return nil
+}
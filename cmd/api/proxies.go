@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// proxyConfig lists the load balancers in front of the API. Anyone can send
+// X-Forwarded-For or X-Real-IP, so they are only believed when the request
+// came from one of these.
+type proxyConfig struct {
+	trusted []netip.Prefix
+}
+
+// parseTrustedProxies reads IP addresses and CIDR ranges
+func parseTrustedProxies(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", value, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	return prefixes, nil
+}
+
+func (c proxyConfig) isTrusted(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range c.trusted {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RealIP replaces RemoteAddr with the client's address forwarded by a trusted
+// proxy. Requests from anywhere else keep the address they connected from.
+func (app *application) RealIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip, ok := app.config.proxies.clientAddr(r); ok {
+			r.RemoteAddr = ip.String()
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientAddr walks X-Forwarded-For from the right, past the trusted proxies
+// that appended to it, to the first address a trusted proxy saw connect. The
+// entries left of it were sent by the client and are ignored.
+func (c proxyConfig) clientAddr(r *http.Request) (netip.Addr, bool) {
+	peer, ok := remoteAddr(r)
+	if !ok || !c.isTrusted(peer) {
+		return netip.Addr{}, false
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		if !c.isTrusted(addr) {
+			return addr.Unmap(), true
+		}
+	}
+
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" && len(hops) == 0 {
+		addr, err := netip.ParseAddr(strings.TrimSpace(realIP))
+		if err != nil {
+			return netip.Addr{}, false
+		}
+		return addr.Unmap(), true
+	}
+
+	return netip.Addr{}, false
+}
+
+func remoteAddr(r *http.Request) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+
+	return addr.Unmap(), true
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := parseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := &application{config: config{proxies: proxyConfig{trusted: trusted}}}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		{
+			name:       "direct client",
+			remoteAddr: "203.0.113.7:4000",
+			want:       "203.0.113.7:4000",
+		},
+		{
+			name:       "spoofed header from an untrusted peer",
+			remoteAddr: "203.0.113.7:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1", "X-Real-IP": "198.51.100.1"},
+			want:       "203.0.113.7:4000",
+		},
+		{
+			name:       "forwarded by a trusted proxy",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "client prepends a fake hop",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Forwarded-For": "198.51.100.1, 203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "192.0.2.1:4000",
+			headers:    map[string]string{"X-Forwarded-For": "203.0.113.7, 10.4.5.6"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "real ip from a trusted proxy",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Real-IP": "203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "malformed forwarded address",
+			remoteAddr: "10.1.2.3:4000",
+			headers:    map[string]string{"X-Forwarded-For": "not-an-ip"},
+			want:       "10.1.2.3:4000",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for key, value := range tt.headers {
+				r.Header.Set(key, value)
+			}
+
+			var got string
+			app.RealIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.RemoteAddr
+			})).ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("got RemoteAddr %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -36,6 +35,26 @@ func (app *application) GetGroupStudySessions(w http.ResponseWriter, r *http.Req
 
 	ctx := r.Context()
 
+	// Sessions of private groups are for members only, which keeps out anyone
+	// banned from the group
+	group, err := app.store.GroupRepository.GetGroupByID(ctx, groupIDInt)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if group.Visibility == "private" {
+		user := r.Context().Value(userCtx).(store.User)
+		isMember, err := app.store.GroupMembership.IsMember(ctx, group.ID, user.ID)
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+		if !isMember {
+			app.writeJSON(w, http.StatusForbidden, "not allowed", nil)
+			return
+		}
+	}
+
 	sessions, err := app.store.Session.GetGroupStudySessions(ctx, groupIDInt)
 	if err != nil {
 		app.internalServerErrorResponse(w, r, err)
@@ -63,28 +82,10 @@ func (app *application) CreateStudySession(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	groupID := chi.URLParam(r, "groupID")
+	groupIDInt := r.Context().Value(groupCtx).(groupAccess).ID
 
-	groupIDInt, err := strconv.Atoi(groupID)
-	if err != nil {
-		app.badRequestResponse(w, r, err)
-		return
-	}
-
-	user := r.Context().Value(userCtx).(store.User)
 	ctx := r.Context()
 
-	// Check if user is admin
-	isAdmin, err := app.store.GroupMembership.IsAdmin(ctx, groupIDInt, user.ID)
-	if err != nil {
-		app.internalServerErrorResponse(w, r, err)
-		return
-	}
-	if !isAdmin {
-		app.badRequestResponse(w, r, errors.New("user is not admin"))
-		return
-	}
-
 	id, err := app.store.Session.CreateStudySession(ctx, &store.StudySession{
 		GroupID:     groupIDInt,
 		Title:       payload.Title,
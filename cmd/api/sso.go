@@ -0,0 +1,208 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/sso"
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+// The login state is also kept in an HttpOnly cookie on the browser that
+// started the flow, so a callback is only accepted from that same browser.
+// Without it an attacker could finish their own login in someone else's
+// browser.
+const (
+	ssoStateCookieName = "oidc_state"
+	ssoStateCookiePath = "/v1/auth/oidc"
+)
+
+// setSSOStateCookie binds the state, nonce and verifier of a login to the browser
+func (app *application) setSSOStateCookie(w http.ResponseWriter, state string, nonce string, verifier string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookieName,
+		Value:    strings.Join([]string{state, nonce, verifier}, "."),
+		Path:     ssoStateCookiePath,
+		Domain:   app.config.cookie.domain,
+		MaxAge:   int(app.config.oidc.stateExp.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: app.config.cookie.sameSite,
+	})
+}
+
+// readSSOStateCookie returns the state, nonce and verifier the browser was
+// given by SSOLogin and clears the cookie, it is good for one callback only
+func (app *application) readSSOStateCookie(w http.ResponseWriter, r *http.Request) (string, string, string, bool) {
+	cookie, err := r.Cookie(ssoStateCookieName)
+	if err != nil {
+		return "", "", "", false
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     ssoStateCookieName,
+		Path:     ssoStateCookiePath,
+		Domain:   app.config.cookie.domain,
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: app.config.cookie.sameSite,
+	})
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 {
+		return "", "", "", false
+	}
+
+	return parts[0], parts[1], parts[2], true
+}
+
+// matches compares two secrets in constant time
+func matches(a string, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// GetSSOProviders lists the login providers that are configured and reachable
+func (app *application) GetSSOProviders(w http.ResponseWriter, r *http.Request) {
+	providers := make([]string, 0, len(app.sso))
+	for name := range app.sso {
+		providers = append(providers, name)
+	}
+	slices.Sort(providers)
+
+	app.writeJSON(w, http.StatusOK, "login providers fetched successfully", providers)
+}
+
+// SSOLogin starts the authorization code flow. The client sends the browser
+// to the returned URL and the provider redirects back to the frontend.
+func (app *application) SSOLogin(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.sso.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		app.notFoundResponse(w, r, err)
+		return
+	}
+
+	state := rand.Text()
+	nonce := rand.Text()
+	verifier := sso.GenerateVerifier()
+
+	err = app.store.Identity.CreateLoginState(r.Context(), state, provider.Name(), verifier, nonce, time.Now().Add(app.config.oidc.stateExp))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	app.setSSOStateCookie(w, state, nonce, verifier)
+
+	app.writeJSON(w, http.StatusOK, "redirect to the login provider", map[string]string{
+		"authorization_url": provider.AuthCodeURL(state, nonce, verifier),
+	})
+}
+
+type SSOCallbackRequest struct {
+	Code  string `json:"code"`
+	State string `json:"state"`
+}
+
+// SSOCallback redeems the code the provider redirected back with. It must come
+// from the browser that started the login. The provider account is matched to
+// a user by a previous link, then by verified email, and otherwise a new user
+// is created.
+func (app *application) SSOCallback(w http.ResponseWriter, r *http.Request) {
+	provider, err := app.sso.Get(chi.URLParam(r, "provider"))
+	if err != nil {
+		app.notFoundResponse(w, r, err)
+		return
+	}
+
+	var payload SSOCallbackRequest
+	err = app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	cookieState, cookieNonce, cookieVerifier, ok := app.readSSOStateCookie(w, r)
+	if !ok || !matches(cookieState, payload.State) {
+		app.badRequestResponse(w, r, errors.New("login was not started in this browser"))
+		return
+	}
+
+	verifier, nonce, err := app.store.Identity.ConsumeLoginState(ctx, payload.State, provider.Name())
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrInvalidToken):
+			app.badRequestResponse(w, r, errors.New("invalid or expired login state"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	if !matches(cookieNonce, nonce) || !matches(cookieVerifier, verifier) {
+		app.badRequestResponse(w, r, errors.New("login was not started in this browser"))
+		return
+	}
+
+	identity, err := provider.Exchange(ctx, payload.Code, verifier, nonce)
+	if err != nil {
+		log.Printf("sso: %s login failed: %v", provider.Name(), err)
+		app.unauthorizedResponse(w, r, errors.New("login with provider failed"))
+		return
+	}
+
+	userID, err := app.store.Identity.GetUserIDByIdentity(ctx, identity.Provider, identity.Subject)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	if errors.Is(err, store.ErrNotFound) {
+		// Only a verified email may be trusted to link or create an account
+		if identity.Email == "" || !identity.EmailVerified {
+			app.unauthorizedResponse(w, r, errors.New("login provider did not return a verified email address"))
+			return
+		}
+
+		existing, err := app.store.User.GetUserByEmail(ctx, identity.Email)
+		switch {
+		case err == nil:
+			userID = existing.ID
+			err = app.store.Identity.LinkIdentity(ctx, userID, identity.Provider, identity.Subject, identity.Email)
+		case errors.Is(err, store.ErrNoRows):
+			var university store.University
+			university, err = app.store.University.UniversityForEmail(ctx, identity.Email)
+			if err != nil {
+				break
+			}
+			userID, err = app.store.Identity.CreateUserWithIdentity(ctx, newSSOUser(identity, university), identity.Provider, identity.Subject)
+		}
+		if err != nil {
+			app.internalServerErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.completeLogin(w, r, userID)
+}
+
+func newSSOUser(identity sso.Identity, university store.University) store.RegisterRequest {
+	firstName := identity.FirstName
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	return store.RegisterRequest{
+		FirstName:    firstName,
+		LastName:     identity.LastName,
+		Email:        identity.Email,
+		UniversityID: university.ID,
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/RakibulBh/studygroup-backend/internal/sso"
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+)
+
+// The SSO tests log in against the docker compose mock-oauth2-server, e.g.
+// TEST_OIDC_ISSUER=http://localhost:8090/default. Its interactive login form
+// lets each test choose the subject and claims of the ID token.
+const testSSOProvider = "mock"
+
+func newTestSSOApplication(t *testing.T) *application {
+	t.Helper()
+
+	issuer := os.Getenv("TEST_OIDC_ISSUER")
+	if issuer == "" {
+		t.Skip("TEST_OIDC_ISSUER is not set")
+	}
+
+	app := newTestApplication(t)
+
+	provider, err := sso.NewProvider(context.Background(), sso.ProviderConfig{
+		Name:         testSSOProvider,
+		IssuerURL:    issuer,
+		ClientID:     "studygroup",
+		ClientSecret: "studygroup",
+		RedirectURL:  app.config.frontendURL + "/auth/callback/" + testSSOProvider,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.sso = sso.Registry{testSSOProvider: provider}
+
+	return app
+}
+
+// startSSOLogin returns the provider URL and the state cookie for a new login
+func (app *application) startSSOLogin(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+
+	w := app.serve(t, http.MethodGet, "/v1/auth/oidc/"+testSSOProvider+"/login", nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: got status %d: %s", w.Code, w.Body.String())
+	}
+
+	var data struct {
+		AuthorizationURL string `json:"authorization_url"`
+	}
+	decodeData(t, w, &data)
+
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == ssoStateCookieName {
+			return data.AuthorizationURL, cookie
+		}
+	}
+	t.Fatal("login did not set the state cookie")
+
+	return "", nil
+}
+
+// authorize signs in at the mock provider and returns the code and state it
+// redirects back with
+func authorize(t *testing.T, authorizationURL string, subject string, claims map[string]any) (string, string) {
+	t.Helper()
+
+	encoded, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.PostForm(authorizationURL, url.Values{
+		"username": {subject},
+		"claims":   {string(encoded)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	location, err := res.Location()
+	if err != nil {
+		if errors.Is(err, http.ErrNoLocation) {
+			t.Fatalf("provider did not redirect, got status %d", res.StatusCode)
+		}
+		t.Fatal(err)
+	}
+
+	query := location.Query()
+	return query.Get("code"), query.Get("state")
+}
+
+func (app *application) ssoCallback(t *testing.T, code string, state string, options ...requestOption) (int, string) {
+	t.Helper()
+
+	w := app.serve(t, http.MethodPost, "/v1/auth/oidc/"+testSSOProvider+"/callback", SSOCallbackRequest{
+		Code:  code,
+		State: state,
+	}, options...)
+	if w.Code != http.StatusAccepted {
+		return w.Code, ""
+	}
+
+	var data struct {
+		AccessToken string `json:"access_token"`
+	}
+	decodeData(t, w, &data)
+
+	return w.Code, data.AccessToken
+}
+
+func verifiedEmailClaims(email string) map[string]any {
+	return map[string]any{
+		"email":          email,
+		"email_verified": true,
+		"given_name":     "Single",
+		"family_name":    "Sign-On",
+	}
+}
+
+func TestSSOCallbackRejectsStateFromAnotherBrowser(t *testing.T) {
+	app := newTestSSOApplication(t)
+
+	_, victimCookie := app.startSSOLogin(t)
+	attackerURL, _ := app.startSSOLogin(t)
+	code, state := authorize(t, attackerURL, testdb.Name("attacker"), verifiedEmailClaims(testdb.Email()))
+
+	status, _ := app.ssoCallback(t, code, state, withCookie(victimCookie))
+	if status != http.StatusBadRequest {
+		t.Fatalf("callback with another login's cookie: got status %d, want %d", status, http.StatusBadRequest)
+	}
+
+	status, _ = app.ssoCallback(t, code, state)
+	if status != http.StatusBadRequest {
+		t.Fatalf("callback without a cookie: got status %d, want %d", status, http.StatusBadRequest)
+	}
+}
+
+func TestSSOCallbackRejectsNonceMismatch(t *testing.T) {
+	app := newTestSSOApplication(t)
+
+	authorizationURL, cookie := app.startSSOLogin(t)
+
+	tampered, err := url.Parse(authorizationURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := tampered.Query()
+	query.Set("nonce", "replayed")
+	tampered.RawQuery = query.Encode()
+
+	code, state := authorize(t, tampered.String(), testdb.Name("subject"), verifiedEmailClaims(testdb.Email()))
+
+	status, _ := app.ssoCallback(t, code, state, withCookie(cookie))
+	if status != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want %d", status, http.StatusUnauthorized)
+	}
+}
+
+func TestSSOCallbackCreatesUser(t *testing.T) {
+	app := newTestSSOApplication(t)
+	ctx := context.Background()
+
+	email := testdb.Email()
+	subject := testdb.Name("subject")
+
+	authorizationURL, cookie := app.startSSOLogin(t)
+	code, state := authorize(t, authorizationURL, subject, verifiedEmailClaims(email))
+
+	status, accessToken := app.ssoCallback(t, code, state, withCookie(cookie))
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", status, http.StatusAccepted)
+	}
+
+	user, err := app.store.User.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatalf("user was not created: %v", err)
+	}
+	if !user.EmailVerified {
+		t.Error("new user's email is not verified")
+	}
+	if user.FirstName != "Single" || user.LastName != "Sign-On" {
+		t.Errorf("got name %q %q, want the provider's", user.FirstName, user.LastName)
+	}
+
+	linkedID, err := app.store.Identity.GetUserIDByIdentity(ctx, testSSOProvider, subject)
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if linkedID != user.ID {
+		t.Errorf("identity linked to user %d, want %d", linkedID, user.ID)
+	}
+	if got := app.tokenUserID(t, accessToken); got != user.ID {
+		t.Errorf("logged in as user %d, want %d", got, user.ID)
+	}
+}
+
+func TestSSOCallbackLinksExistingUser(t *testing.T) {
+	app := newTestSSOApplication(t)
+	ctx := context.Background()
+
+	email := testdb.Email()
+	subject := testdb.Name("subject")
+	userID := app.createUser(t, email, "correct horse battery staple", true)
+
+	authorizationURL, cookie := app.startSSOLogin(t)
+	code, state := authorize(t, authorizationURL, subject, verifiedEmailClaims(email))
+
+	status, accessToken := app.ssoCallback(t, code, state, withCookie(cookie))
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", status, http.StatusAccepted)
+	}
+
+	linkedID, err := app.store.Identity.GetUserIDByIdentity(ctx, testSSOProvider, subject)
+	if err != nil {
+		t.Fatalf("identity was not linked: %v", err)
+	}
+	if linkedID != userID {
+		t.Errorf("identity linked to user %d, want %d", linkedID, userID)
+	}
+	if got := app.tokenUserID(t, accessToken); got != userID {
+		t.Errorf("logged in as user %d, want %d", got, userID)
+	}
+
+	// A verified account keeps its password
+	user, err := app.store.User.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash == "" {
+		t.Error("linking cleared the password of a verified account")
+	}
+}
+
+func TestSSOCallbackResetsUnverifiedUser(t *testing.T) {
+	app := newTestSSOApplication(t)
+	ctx := context.Background()
+
+	// Someone registered the address first without being able to verify it
+	email := testdb.Email()
+	userID := app.createUser(t, email, "squatted password", false)
+
+	authorizationURL, cookie := app.startSSOLogin(t)
+	code, state := authorize(t, authorizationURL, testdb.Name("subject"), verifiedEmailClaims(email))
+
+	status, accessToken := app.ssoCallback(t, code, state, withCookie(cookie))
+	if status != http.StatusAccepted {
+		t.Fatalf("got status %d, want %d", status, http.StatusAccepted)
+	}
+	if got := app.tokenUserID(t, accessToken); got != userID {
+		t.Errorf("logged in as user %d, want %d", got, userID)
+	}
+
+	user, err := app.store.User.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != "" {
+		t.Error("the password set before the email was verified still works")
+	}
+	if !user.EmailVerified {
+		t.Error("email is not verified after linking")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type loginConfig struct {
+	// Failures older than window are forgotten
+	window time.Duration
+	// After delayAfter failures each further attempt must wait baseDelay,
+	// doubling with every failure
+	delayAfter int
+	baseDelay  time.Duration
+	// After lockAfter failures the account is locked for lockDuration
+	lockAfter    int
+	lockDuration time.Duration
+	// After ipLockAfter failures across any emails the IP is locked
+	ipLockAfter int
+}
+
+// dummyPasswordHash is compared against when the email is unknown, so a login
+// for a missing account takes as long as one with a wrong password
+var dummyPasswordHash = func() string {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	return string(hash)
+}()
+
+// beginLoginAttempt records a login attempt for the email from the IP
+// address. When the account or IP is throttled nothing is recorded and the
+// time the client must wait is returned instead.
+func (app *application) beginLoginAttempt(ctx context.Context, email string, ip string) (store.LoginAttempt, time.Duration, error) {
+	cfg := app.config.login
+
+	return app.store.LoginAttempts.BeginAttempt(ctx, email, ip, time.Now().Add(-cfg.window), cfg.retryAfter)
+}
+
+// retryAfter returns how long the client must wait before another attempt
+// will be considered
+func (cfg loginConfig) retryAfter(failures store.LoginFailures) time.Duration {
+	var unlockAt time.Time
+	switch {
+	case failures.Account >= cfg.lockAfter:
+		unlockAt = failures.AccountLastFailure.Add(cfg.lockDuration)
+	case failures.Account >= cfg.delayAfter:
+		delay := min(cfg.baseDelay<<(failures.Account-cfg.delayAfter), cfg.lockDuration)
+		unlockAt = failures.AccountLastFailure.Add(delay)
+	}
+
+	if failures.IP >= cfg.ipLockAfter {
+		unlockAt = later(unlockAt, failures.IPLastFailure.Add(cfg.lockDuration))
+	}
+
+	return time.Until(unlockAt)
+}
+
+// recordLoginFailure records a lockout event when the failed attempt is the
+// one that locks the account or IP address. The attempt itself was stored as
+// a failure when it began.
+func (app *application) recordLoginFailure(ctx context.Context, attempt store.LoginAttempt, email string, ip string) error {
+	cfg := app.config.login
+	failures := attempt.Failures
+
+	lockedUntil := time.Now().Add(cfg.lockDuration)
+
+	if failures.Account >= cfg.lockAfter {
+		locked, err := app.store.LoginAttempts.RecordLockout(ctx, "account", email, ip, failures.Account, lockedUntil)
+		if err != nil {
+			return err
+		}
+		if locked {
+			log.Printf("security: account %q locked after %d failed logins, last from %s", email, failures.Account, ip)
+		}
+	}
+
+	if failures.IP >= cfg.ipLockAfter {
+		locked, err := app.store.LoginAttempts.RecordLockout(ctx, "ip", "", ip, failures.IP, lockedUntil)
+		if err != nil {
+			return err
+		}
+		if locked {
+			log.Printf("security: IP %s locked after %d failed logins", ip, failures.IP)
+		}
+	}
+
+	return nil
+}
+
+func later(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
+
+// normalizeEmail is the key login attempts are tracked under
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP returns the caller's address. RealIP has already replaced
+// RemoteAddr with the address a trusted proxy forwarded, if any.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	defaultUniversityLimit  = 50
+	maxUniversityLimit      = 100
+	maxUniversityNameLength = 255
+)
+
+var (
+	countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+	domainPattern      = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]*[a-z0-9])?\.)+[a-z]{2,}$`)
+)
+
+// GetUniversities lists the university catalog for the signup form, optionally
+// narrowed down by ?search= and capped by ?limit=
+func (app *application) GetUniversities(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("search"))
+
+	limit := defaultUniversityLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxUniversityLimit {
+			app.badRequestResponse(w, r, fmt.Errorf("limit must be between 1 and %d", maxUniversityLimit))
+			return
+		}
+	}
+
+	universities, err := app.store.University.ListUniversities(r.Context(), search, limit)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "universities fetched successfully", universities)
+}
+
+type CreateUniversityRequest struct {
+	Name         string   `json:"name"`
+	Country      string   `json:"country"`
+	EmailDomains []string `json:"email_domains"`
+	Timezone     string   `json:"timezone"`
+}
+
+// CreateUniversity adds a university to the catalog
+func (app *application) CreateUniversity(w http.ResponseWriter, r *http.Request) {
+	var payload CreateUniversityRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	university := store.University{
+		Name:     strings.TrimSpace(payload.Name),
+		Country:  strings.ToUpper(strings.TrimSpace(payload.Country)),
+		Timezone: strings.TrimSpace(payload.Timezone),
+	}
+
+	if len(university.Name) < 2 || len(university.Name) > maxUniversityNameLength {
+		app.badRequestResponse(w, r, fmt.Errorf("name must be between 2 and %d characters", maxUniversityNameLength))
+		return
+	}
+	if !countryCodePattern.MatchString(university.Country) {
+		app.badRequestResponse(w, r, errors.New("country must be a two letter ISO 3166 code"))
+		return
+	}
+
+	// An IANA name such as Europe/London, not an abbreviation or offset
+	if university.Timezone == "" || university.Timezone == "Local" {
+		app.badRequestResponse(w, r, errors.New("timezone is required"))
+		return
+	}
+	_, err = time.LoadLocation(university.Timezone)
+	if err != nil {
+		app.badRequestResponse(w, r, fmt.Errorf("unknown timezone %q", university.Timezone))
+		return
+	}
+
+	// Without domains anyone could claim the university, which is reserved for
+	// the Other catch-all
+	if len(payload.EmailDomains) == 0 {
+		app.badRequestResponse(w, r, errors.New("at least one email domain is required"))
+		return
+	}
+	for _, domain := range payload.EmailDomains {
+		domain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+		if !domainPattern.MatchString(domain) {
+			app.badRequestResponse(w, r, fmt.Errorf("invalid email domain %q", domain))
+			return
+		}
+		university.EmailDomains = append(university.EmailDomains, domain)
+	}
+	slices.Sort(university.EmailDomains)
+	university.EmailDomains = slices.Compact(university.EmailDomains)
+
+	err = app.store.University.CreateUniversity(r.Context(), &university)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrConflict):
+			app.errorJSON(w, errors.New("a university with this name or one of these email domains already exists"), http.StatusConflict)
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusCreated, "university created successfully", university)
+}
+
+type MergeUniversityRequest struct {
+	IntoID int `json:"into_id"`
+}
+
+// MergeUniversity folds the university in the path into another one, moving
+// its users and email domains across. Used to clean up duplicates.
+func (app *application) MergeUniversity(w http.ResponseWriter, r *http.Request) {
+	sourceID, err := strconv.Atoi(chi.URLParam(r, "universityID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid university id"))
+		return
+	}
+
+	var payload MergeUniversityRequest
+	err = app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+	if payload.IntoID == sourceID {
+		app.badRequestResponse(w, r, errors.New("a university cannot be merged into itself"))
+		return
+	}
+
+	ctx := r.Context()
+
+	// Other catches every email nobody else claims, so it must stay as it is
+	for _, id := range []int{sourceID, payload.IntoID} {
+		university, err := app.store.University.GetUniversity(ctx, id)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.notFoundResponse(w, r, fmt.Errorf("university %d not found", id))
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+		if university.Name == store.OtherUniversityName {
+			app.badRequestResponse(w, r, fmt.Errorf("the %s university cannot be merged", store.OtherUniversityName))
+			return
+		}
+	}
+
+	university, err := app.store.University.MergeUniversities(ctx, sourceID, payload.IntoID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("university not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, "universities merged successfully", university)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/RakibulBh/studygroup-backend/internal/images"
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+)
+
+// Uploaded images are re-encoded at these sizes, the original is never kept
+const (
+	avatarSize          = 512
+	avatarThumbnailSize = 128
+
+	coverWidth           = 1500
+	coverHeight          = 500
+	coverThumbnailWidth  = 480
+	coverThumbnailHeight = 160
+
+	imageFormField = "image"
+)
+
+var errUploadTooLarge = errors.New("image is too large")
+
+// readImageUpload reads and decodes the image field of a multipart upload. The
+// type is sniffed from the bytes, whatever the client claims.
+func (app *application) readImageUpload(w http.ResponseWriter, r *http.Request) (image.Image, error) {
+	maxBytes := app.config.uploads.maxBytes
+
+	// Leave room for the rest of the multipart body around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes+64*1024)
+
+	file, header, err := r.FormFile(imageFormField)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errUploadTooLarge
+		}
+		return nil, fmt.Errorf("a multipart %q file is required", imageFormField)
+	}
+	defer file.Close()
+
+	if header.Size > maxBytes {
+		return nil, errUploadTooLarge
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, errUploadTooLarge
+	}
+
+	return images.Decode(data)
+}
+
+// writeUploadError reports a readImageUpload failure
+func (app *application) writeUploadError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errUploadTooLarge):
+		app.errorJSON(w, fmt.Errorf("image must be at most %d KB", app.config.uploads.maxBytes/1024), http.StatusRequestEntityTooLarge)
+	default:
+		app.badRequestResponse(w, r, err)
+	}
+}
+
+// storeImage uploads the image and its thumbnail under a new key in dir
+func (app *application) storeImage(ctx context.Context, dir string, full image.Image, thumbnail image.Image) (string, error) {
+	key := fmt.Sprintf("%s/%s.jpg", dir, strings.ToLower(rand.Text()))
+
+	for blobKey, img := range map[string]image.Image{key: full, thumbnailKey(key): thumbnail} {
+		data, err := images.EncodeJPEG(img)
+		if err != nil {
+			return "", err
+		}
+
+		err = app.blobs.Put(ctx, blobKey, "image/jpeg", data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return key, nil
+}
+
+// deleteImage removes an image and its thumbnail. Failures only leave an
+// orphaned blob behind, so they are logged rather than returned.
+func (app *application) deleteImage(ctx context.Context, key string) {
+	if key == "" {
+		return
+	}
+
+	for _, blobKey := range []string{key, thumbnailKey(key)} {
+		err := app.blobs.Delete(ctx, blobKey)
+		if err != nil {
+			log.Printf("failed to delete blob %s: %v", blobKey, err)
+		}
+	}
+}
+
+func thumbnailKey(key string) string {
+	return strings.TrimSuffix(key, ".jpg") + "_thumb.jpg"
+}
+
+// imageURLs returns the public URLs of an image and its thumbnail
+func (app *application) imageURLs(key string) (string, string) {
+	if key == "" {
+		return "", ""
+	}
+
+	return app.blobs.URL(key), app.blobs.URL(thumbnailKey(key))
+}
+
+func (app *application) setAvatarURLs(profile *store.UserProfile) {
+	profile.AvatarURL, profile.AvatarThumbnailURL = app.imageURLs(profile.AvatarKey)
+}
+
+func (app *application) setCoverURLs(groups []store.Group) {
+	for i := range groups {
+		groups[i].CoverURL, groups[i].CoverThumbnailURL = app.imageURLs(groups[i].CoverKey)
+	}
+}
+
+// UploadAvatar replaces the signed in user's avatar with a multipart image
+func (app *application) UploadAvatar(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	img, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.writeUploadError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	key, err := app.storeImage(ctx, fmt.Sprintf("avatars/%d", user.ID), images.Fill(img, avatarSize, avatarSize), images.Fill(img, avatarThumbnailSize, avatarThumbnailSize))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	previousKey, err := app.store.User.SetAvatar(ctx, user.ID, key)
+	if err != nil {
+		app.deleteImage(ctx, key)
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	app.deleteImage(ctx, previousKey)
+
+	url, thumbnailURL := app.imageURLs(key)
+	app.writeJSON(w, http.StatusOK, "avatar updated successfully", map[string]string{
+		"avatar_url":           url,
+		"avatar_thumbnail_url": thumbnailURL,
+	})
+}
+
+func (app *application) DeleteAvatar(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	previousKey, err := app.store.User.SetAvatar(r.Context(), user.ID, "")
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	app.deleteImage(r.Context(), previousKey)
+
+	app.writeJSON(w, http.StatusOK, "avatar removed successfully", nil)
+}
+
+// UploadGroupCover replaces a group's cover image
+func (app *application) UploadGroupCover(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	img, err := app.readImageUpload(w, r)
+	if err != nil {
+		app.writeUploadError(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	key, err := app.storeImage(ctx, fmt.Sprintf("groups/%d", groupID), images.Fill(img, coverWidth, coverHeight), images.Fill(img, coverThumbnailWidth, coverThumbnailHeight))
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	previousKey, err := app.store.GroupRepository.SetCoverImage(ctx, groupID, key)
+	if err != nil {
+		app.deleteImage(ctx, key)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("group not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteImage(ctx, previousKey)
+
+	url, thumbnailURL := app.imageURLs(key)
+	app.writeJSON(w, http.StatusOK, "cover image updated successfully", map[string]string{
+		"cover_url":           url,
+		"cover_thumbnail_url": thumbnailURL,
+	})
+}
+
+func (app *application) DeleteGroupCover(w http.ResponseWriter, r *http.Request) {
+	groupID := r.Context().Value(groupCtx).(groupAccess).ID
+
+	previousKey, err := app.store.GroupRepository.SetCoverImage(r.Context(), groupID, "")
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			app.notFoundResponse(w, r, errors.New("group not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+	app.deleteImage(r.Context(), previousKey)
+
+	app.writeJSON(w, http.StatusOK, "cover image removed successfully", nil)
+}
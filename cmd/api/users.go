@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/RakibulBh/studygroup-backend/internal/store"
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	maxNameLength     = 100
+	maxBioLength      = 500
+	maxPronounsLength = 50
+	maxCourseLength   = 100
+	maxYearOfStudy    = 7
+	maxModules        = 20
+	maxModuleLength   = 50
+
+	minUserSearchLength = 2
+	defaultUserLimit    = 20
+	maxUserLimit        = 50
+)
+
+// UpdateUserRequest changes only the fields that are sent. A year_of_study of
+// 0 clears it.
+type UpdateUserRequest struct {
+	FirstName         *string   `json:"first_name"`
+	LastName          *string   `json:"last_name"`
+	UniversityID      *int      `json:"university_id"`
+	Bio               *string   `json:"bio"`
+	Pronouns          *string   `json:"pronouns"`
+	YearOfStudy       *int      `json:"year_of_study"`
+	Course            *string   `json:"course"`
+	Modules           *[]string `json:"modules"`
+	ProfileVisibility *string   `json:"profile_visibility"`
+	SharedFields      *[]string `json:"shared_fields"`
+}
+
+// GetProfile returns the signed in user's full profile and privacy settings
+func (app *application) GetProfile(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	profile, err := app.store.User.GetProfile(r.Context(), user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	app.setAvatarURLs(&profile)
+
+	app.writeJSON(w, http.StatusOK, "profile fetched successfully", profile)
+}
+
+func (app *application) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	user := r.Context().Value(userCtx).(store.User)
+
+	var payload UpdateUserRequest
+	err := app.readJSON(r, &payload)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	ctx := r.Context()
+
+	profile, err := app.store.User.GetProfile(ctx, user.ID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	// Names follow the same rules as Register
+	if payload.FirstName != nil {
+		profile.FirstName = strings.TrimSpace(*payload.FirstName)
+		if len(profile.FirstName) < 2 || len(profile.FirstName) > maxNameLength {
+			app.badRequestResponse(w, r, fmt.Errorf("first name must be between 2 and %d characters", maxNameLength))
+			return
+		}
+	}
+	if payload.LastName != nil {
+		profile.LastName = strings.TrimSpace(*payload.LastName)
+		if len(profile.LastName) < 2 || len(profile.LastName) > maxNameLength {
+			app.badRequestResponse(w, r, fmt.Errorf("last name must be between 2 and %d characters", maxNameLength))
+			return
+		}
+	}
+
+	// As on Register, the university must exist and own the user's email
+	if payload.UniversityID != nil {
+		university, err := app.store.University.GetUniversity(ctx, *payload.UniversityID)
+		if err != nil {
+			switch {
+			case errors.Is(err, store.ErrNotFound):
+				app.badRequestResponse(w, r, errors.New("invalid university"))
+			default:
+				app.internalServerErrorResponse(w, r, err)
+			}
+			return
+		}
+		if !university.AllowsEmail(user.Email) {
+			app.badRequestResponse(w, r, errors.New("email address does not belong to the selected university"))
+			return
+		}
+		profile.UniversityID = university.ID
+		profile.University = university.Name
+	}
+
+	if payload.Bio != nil {
+		profile.Bio = strings.TrimSpace(*payload.Bio)
+		if len(profile.Bio) > maxBioLength {
+			app.badRequestResponse(w, r, fmt.Errorf("bio must be at most %d characters", maxBioLength))
+			return
+		}
+	}
+	if payload.Pronouns != nil {
+		profile.Pronouns = strings.TrimSpace(*payload.Pronouns)
+		if len(profile.Pronouns) > maxPronounsLength {
+			app.badRequestResponse(w, r, fmt.Errorf("pronouns must be at most %d characters", maxPronounsLength))
+			return
+		}
+	}
+	if payload.YearOfStudy != nil {
+		year := *payload.YearOfStudy
+		if year < 0 || year > maxYearOfStudy {
+			app.badRequestResponse(w, r, fmt.Errorf("year of study must be between 1 and %d", maxYearOfStudy))
+			return
+		}
+
+		profile.YearOfStudy = nil
+		if year > 0 {
+			profile.YearOfStudy = &year
+		}
+	}
+	if payload.Course != nil {
+		profile.Course = strings.TrimSpace(*payload.Course)
+		if len(profile.Course) > maxCourseLength {
+			app.badRequestResponse(w, r, fmt.Errorf("course must be at most %d characters", maxCourseLength))
+			return
+		}
+	}
+
+	if payload.Modules != nil {
+		if len(*payload.Modules) > maxModules {
+			app.badRequestResponse(w, r, fmt.Errorf("at most %d modules can be listed", maxModules))
+			return
+		}
+
+		// Module codes are compared case insensitively, the first spelling wins
+		profile.Modules = []string{}
+		for _, module := range *payload.Modules {
+			module = strings.TrimSpace(module)
+			if module == "" || len(module) > maxModuleLength {
+				app.badRequestResponse(w, r, fmt.Errorf("modules must be between 1 and %d characters", maxModuleLength))
+				return
+			}
+			if !slices.ContainsFunc(profile.Modules, func(existing string) bool { return strings.EqualFold(existing, module) }) {
+				profile.Modules = append(profile.Modules, module)
+			}
+		}
+	}
+
+	if payload.ProfileVisibility != nil {
+		switch *payload.ProfileVisibility {
+		case store.ProfileVisibilityPublic, store.ProfileVisibilityUniversity, store.ProfileVisibilityPrivate:
+			profile.Visibility = *payload.ProfileVisibility
+		default:
+			app.badRequestResponse(w, r, errors.New("profile visibility must be public, university or private"))
+			return
+		}
+	}
+	if payload.SharedFields != nil {
+		sharedFields := *payload.SharedFields
+		for _, field := range sharedFields {
+			if !slices.Contains(store.ProfileFields, field) {
+				app.badRequestResponse(w, r, fmt.Errorf("unknown profile field %q", field))
+				return
+			}
+		}
+
+		slices.Sort(sharedFields)
+		profile.SharedFields = slices.Compact(sharedFields)
+	}
+
+	err = app.store.User.UpdateProfile(ctx, profile)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	app.setAvatarURLs(&profile)
+
+	app.writeJSON(w, http.StatusOK, "profile updated successfully", profile)
+}
+
+// GetPublicProfile shows another user's profile, limited to what they share.
+// Profiles the caller may not see are reported as not found.
+func (app *application) GetPublicProfile(w http.ResponseWriter, r *http.Request) {
+	viewer := r.Context().Value(userCtx).(store.User)
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		app.badRequestResponse(w, r, errors.New("invalid user id"))
+		return
+	}
+
+	profile, err := app.store.User.GetProfile(r.Context(), userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNoRows):
+			app.notFoundResponse(w, r, errors.New("user not found"))
+		default:
+			app.internalServerErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if !profile.VisibleTo(viewer) {
+		app.notFoundResponse(w, r, errors.New("user not found"))
+		return
+	}
+
+	// Users who blocked each other do not see each other's profiles
+	blocked, err := app.store.UserBlocks.IsBlocked(r.Context(), viewer.ID, userID)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+	if blocked {
+		app.notFoundResponse(w, r, errors.New("user not found"))
+		return
+	}
+
+	app.setAvatarURLs(&profile)
+
+	app.writeJSON(w, http.StatusOK, "profile fetched successfully", profile.Public())
+}
+
+type UserSearchResponse struct {
+	Users   []store.PublicProfile `json:"users"`
+	Page    int                   `json:"page"`
+	Limit   int                   `json:"limit"`
+	HasMore bool                  `json:"has_more"`
+}
+
+// SearchUsers looks for classmates at the caller's university by name, or by
+// the course and modules they share, with ?q=, ?page= and ?limit=. Results
+// only carry public profile fields, never emails.
+func (app *application) SearchUsers(w http.ResponseWriter, r *http.Request) {
+	viewer := r.Context().Value(userCtx).(store.User)
+	query := r.URL.Query()
+
+	search := strings.TrimSpace(query.Get("q"))
+	if len(search) < minUserSearchLength {
+		app.badRequestResponse(w, r, fmt.Errorf("search must be at least %d characters", minUserSearchLength))
+		return
+	}
+
+	page := 1
+	if value := query.Get("page"); value != "" {
+		var err error
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 {
+			app.badRequestResponse(w, r, errors.New("page must be a positive number"))
+			return
+		}
+	}
+
+	limit := defaultUserLimit
+	if value := query.Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxUserLimit {
+			app.badRequestResponse(w, r, fmt.Errorf("limit must be between 1 and %d", maxUserLimit))
+			return
+		}
+	}
+
+	// One extra row tells whether there is another page
+	profiles, err := app.store.User.SearchUsers(r.Context(), viewer, search, limit+1, (page-1)*limit)
+	if err != nil {
+		app.internalServerErrorResponse(w, r, err)
+		return
+	}
+
+	response := UserSearchResponse{
+		Users:   []store.PublicProfile{},
+		Page:    page,
+		Limit:   limit,
+		HasMore: len(profiles) > limit,
+	}
+	for _, profile := range profiles[:min(len(profiles), limit)] {
+		if !profile.VisibleTo(viewer) {
+			continue
+		}
+
+		app.setAvatarURLs(&profile)
+		response.Users = append(response.Users, profile.Public())
+	}
+
+	app.writeJSON(w, http.StatusOK, "users fetched successfully", response)
+}
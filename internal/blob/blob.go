@@ -0,0 +1,18 @@
+// Package blob stores uploaded files such as avatars and group covers.
+package blob
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrInvalidKey = errors.New("invalid blob key")
+
+// Store keeps blobs under slash separated keys, e.g. "avatars/12/abc.jpg", and
+// knows the public URL each one is served from.
+type Store interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	// Delete removes a blob, deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+	URL(key string) string
+}
@@ -0,0 +1,118 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores blobs as files under a directory. The API serves them itself
+// through Handler, so it suits development and single instance deployments.
+type Local struct {
+	dir     string
+	baseURL string
+}
+
+// NewLocal stores blobs under dir. baseURL is where Handler is mounted, e.g.
+// "http://localhost:8080/media".
+func NewLocal(dir string, baseURL string) (*Local, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{dir: dir, baseURL: strings.TrimSuffix(baseURL, "/")}, nil
+}
+
+// path maps a key to its file, rejecting keys that would escape the directory
+func (l *Local) path(key string) (string, error) {
+	if !fs.ValidPath(key) || key == "." {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first so readers never see a
+// partially written one
+func (l *Local) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(tmp.Name(), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	name, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (l *Local) URL(key string) string {
+	return l.baseURL + "/" + key
+}
+
+// Handler serves stored blobs. It must be mounted with the base URL prefix
+// stripped. Directories are never listed.
+func (l *Local) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, err := l.path(strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		info, err := os.Stat(name)
+		if err != nil || info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			http.NotFound(w, r)
+			return
+		}
+
+		// Keys are never reused, a new upload always gets a new key
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeFile(w, r, name)
+	})
+}
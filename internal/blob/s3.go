@@ -0,0 +1,79 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+type S3Config struct {
+	// Endpoint is the host[:port] of the S3 API, e.g. "s3.eu-west-2.amazonaws.com"
+	// or "localhost:9000" for MinIO
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	UseSSL    bool
+	// PathStyle addresses the bucket as endpoint/bucket rather than
+	// bucket.endpoint, which MinIO needs
+	PathStyle bool
+	// PublicURL is where objects can be read from, e.g. a CDN in front of the
+	// bucket. It defaults to the bucket on the endpoint, which must then allow
+	// anonymous reads.
+	PublicURL string
+}
+
+// S3 stores blobs in a bucket of any S3-compatible service
+type S3 struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, ""),
+		Secure:       cfg.UseSSL,
+		Region:       cfg.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	publicURL := cfg.PublicURL
+	if publicURL == "" {
+		scheme := "http"
+		if cfg.UseSSL {
+			scheme = "https"
+		}
+		publicURL = scheme + "://" + cfg.Endpoint + "/" + cfg.Bucket
+	}
+
+	return &S3{client: client, bucket: cfg.Bucket, publicURL: strings.TrimSuffix(publicURL, "/")}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		ContentType:  contentType,
+		CacheControl: "public, max-age=31536000, immutable",
+	})
+	return err
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+func (s *S3) URL(key string) string {
+	return s.publicURL + "/" + key
+}
@@ -0,0 +1,93 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+)
+
+// The S3 test runs against the docker compose MinIO when TEST_S3_ENDPOINT is
+// set, e.g. to localhost:9000
+func newTestS3(t *testing.T) *S3 {
+	t.Helper()
+
+	endpoint := os.Getenv("TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TEST_S3_ENDPOINT is not set")
+	}
+
+	store, err := NewS3(S3Config{
+		Endpoint:  endpoint,
+		Region:    "us-east-1",
+		Bucket:    "studygroup",
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+		PathStyle: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store
+}
+
+func get(t *testing.T, url string) (int, string, []byte) {
+	t.Helper()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, res.Header.Get("Content-Type"), body
+}
+
+func TestS3RoundTrip(t *testing.T) {
+	store := newTestS3(t)
+	ctx := context.Background()
+
+	key := "tests/" + rand.Text() + ".jpg"
+	data := []byte(rand.Text())
+
+	err := store.Put(ctx, key, "image/jpeg", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, contentType, body := get(t, store.URL(key))
+	if status != http.StatusOK {
+		t.Fatalf("reading the blob back: got status %d", status)
+	}
+	if contentType != "image/jpeg" {
+		t.Errorf("got content type %q, want image/jpeg", contentType)
+	}
+	if !bytes.Equal(body, data) {
+		t.Errorf("got %q, want %q", body, data)
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, _, _ = get(t, store.URL(key))
+	if status != http.StatusNotFound {
+		t.Fatalf("reading a deleted blob: got status %d, want %d", status, http.StatusNotFound)
+	}
+
+	// Deleting it again is not an error
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
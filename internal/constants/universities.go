@@ -1,16 +0,0 @@
-package constants
-
-// Universities contains all supported universities
-var Universities = []string{
-	"Brunel University London",
-	"University of California, Berkeley",
-	"Stanford University",
-	"Massachusetts Institute of Technology",
-	"Harvard University",
-	"Yale University",
-	"Columbia University",
-	"Princeton University",
-	"University of Chicago",
-	"University of Pennsylvania",
-	"Other",
-}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is an arbitrary key for the advisory lock that stops two
+// migrate processes from running against the same database at once.
+const migrationLockID = 4792017263
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+var (
+	ErrUnknownVersion = errors.New("unknown migration version")
+	ErrDirtyDatabase  = errors.New("database has unknown migrations applied")
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int64      `json:"version"`
+	Name      string     `json:"name"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// loadMigrations reads every NNNNNN_name.(up|down).sql file and pairs them by
+// version, sorted ascending. Every version must have both an up and a down file.
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		matches := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if matches == nil {
+			return nil, fmt.Errorf("invalid migration file name %q", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(matches[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %q: %w", entry.Name(), err)
+		}
+
+		contents, err := fs.ReadFile(fsys, path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: matches[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != matches[2] {
+			return nil, fmt.Errorf("migration %d has mismatched names %q and %q", version, migration.Name, matches[2])
+		}
+
+		if matches[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing its up or down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return migrations, nil
+}
+
+// Up applies up to steps pending migrations in order. A steps value of zero or
+// less applies every pending migration.
+func (m *Migrator) Up(ctx context.Context, steps int) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if steps > 0 && len(applied) == steps {
+				break
+			}
+			if _, ok := current[migration.Version]; ok {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			applied = append(applied, migration)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down rolls back up to steps applied migrations, newest first. A steps value
+// of zero or less rolls back every applied migration.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range slices.Backward(m.migrations) {
+			if steps > 0 && len(reverted) == steps {
+				break
+			}
+			if _, ok := current[migration.Version]; !ok {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+			reverted = append(reverted, migration)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// To migrates up or down until exactly the migrations with a version less than
+// or equal to the target are applied. A target of zero reverts everything.
+func (m *Migrator) To(ctx context.Context, target int64) ([]Migration, error) {
+	if target != 0 && !slices.ContainsFunc(m.migrations, func(migration Migration) bool { return migration.Version == target }) {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownVersion, target)
+	}
+
+	var changed []Migration
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		// Roll back anything above the target first, newest first
+		for _, migration := range slices.Backward(m.migrations) {
+			if _, ok := current[migration.Version]; !ok || migration.Version <= target {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration, false)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, migration)
+		}
+
+		// Then apply anything missing at or below the target
+		for _, migration := range m.migrations {
+			if _, ok := current[migration.Version]; ok || migration.Version > target {
+				continue
+			}
+
+			err := m.apply(ctx, conn, migration, true)
+			if err != nil {
+				return err
+			}
+			changed = append(changed, migration)
+		}
+
+		return nil
+	})
+
+	return changed, err
+}
+
+// Status lists every known migration and when it was applied, if at all.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		current, err := m.appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := current[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+
+		return nil
+	})
+
+	return statuses, err
+}
+
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	// Advisory locks belong to a session, so pin a single connection
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID)
+	if err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+
+	query := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`
+	_, err = conn.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func (m *Migrator) appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	query := `
+		SELECT version, applied_at FROM schema_migrations
+	`
+
+	rows, err := conn.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		// Refuse to touch a database migrated by a newer build than this one
+		if !slices.ContainsFunc(m.migrations, func(migration Migration) bool { return migration.Version == version }) {
+			return nil, fmt.Errorf("%w: %d", ErrDirtyDatabase, version)
+		}
+
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// apply runs a single migration and records it in the same transaction, so a
+// failing migration leaves neither the schema change nor the version behind.
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, migration Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	script, record := migration.Down, `DELETE FROM schema_migrations WHERE version = $1`
+	if up {
+		script, record = migration.Up, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`
+	}
+
+	_, err = tx.ExecContext(ctx, script)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.Version, migration.Name, err)
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, record, migration.Version, migration.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, record, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
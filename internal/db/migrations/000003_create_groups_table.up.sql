@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS groups (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    has_member_limit BOOLEAN NOT NULL DEFAULT FALSE,
+    member_limit INT,
+    description VARCHAR(500) NOT NULL,
+    subject VARCHAR(100) NOT NULL,
+    location VARCHAR(100) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    visibility VARCHAR(10) NOT NULL DEFAULT 'public' CHECK (visibility IN ('public', 'private'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_groups_name ON groups (name);
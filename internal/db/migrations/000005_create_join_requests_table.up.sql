@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS join_requests (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    group_id BIGINT NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    UNIQUE (user_id, group_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_join_requests_group_id ON join_requests (group_id);
@@ -0,0 +1,3 @@
+ALTER TABLE users DROP COLUMN IF EXISTS tokens_revoked_at;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS revoked_at;
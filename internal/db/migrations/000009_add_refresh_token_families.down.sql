@@ -0,0 +1,12 @@
+-- Raw tokens cannot be recovered from their hashes, so every session is dropped.
+DELETE FROM refresh_tokens;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_family_id;
+DROP INDEX IF EXISTS idx_refresh_tokens_token_hash;
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token TEXT NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_token ON refresh_tokens (token);
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS family_id;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token_hash;
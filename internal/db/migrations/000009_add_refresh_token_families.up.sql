@@ -0,0 +1,18 @@
+-- Refresh tokens are stored as SHA-256 hashes and grouped into families: every
+-- rotation stays in the family of the login that started it.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS token_hash TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS family_id UUID;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS rotated_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens
+SET token_hash = encode(sha256(convert_to(token, 'UTF8')), 'hex'),
+    family_id = gen_random_uuid();
+
+ALTER TABLE refresh_tokens ALTER COLUMN token_hash SET NOT NULL;
+ALTER TABLE refresh_tokens ALTER COLUMN family_id SET NOT NULL;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_token;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS token;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMPTZ;
+
+-- Accounts created before verification existed are trusted as they are.
+UPDATE users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
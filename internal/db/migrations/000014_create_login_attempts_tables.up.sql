@@ -0,0 +1,25 @@
+-- Attempts are tracked by the email typed in, not by user, so unknown emails
+-- are throttled exactly like registered ones.
+CREATE TABLE IF NOT EXISTS login_attempts (
+    id BIGSERIAL PRIMARY KEY,
+    email VARCHAR(255) NOT NULL,
+    ip TEXT NOT NULL,
+    succeeded BOOLEAN NOT NULL,
+    attempted_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip, attempted_at);
+CREATE INDEX IF NOT EXISTS idx_login_attempts_attempted_at ON login_attempts (attempted_at);
+
+CREATE TABLE IF NOT EXISTS login_lockouts (
+    id BIGSERIAL PRIMARY KEY,
+    scope VARCHAR(10) NOT NULL CHECK (scope IN ('account', 'ip')),
+    email VARCHAR(255),
+    ip TEXT NOT NULL,
+    failures INT NOT NULL,
+    locked_until TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_lockouts_email ON login_lockouts (email);
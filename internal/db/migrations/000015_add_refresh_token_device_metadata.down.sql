@@ -0,0 +1,3 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS last_used_at;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS ip;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS user_agent;
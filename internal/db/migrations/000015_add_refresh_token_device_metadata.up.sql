@@ -0,0 +1,7 @@
+-- A token family is one signed in device. Each rotation copies the latest
+-- device details onto the new token.
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS ip TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+UPDATE refresh_tokens SET last_used_at = created_at;
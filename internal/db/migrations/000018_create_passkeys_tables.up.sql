@@ -0,0 +1,23 @@
+CREATE TABLE IF NOT EXISTS passkeys (
+    id BIGSERIAL PRIMARY KEY,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    credential_id BYTEA NOT NULL UNIQUE,
+    -- The full WebAuthn credential record: public key, sign count, flags, etc.
+    credential JSONB NOT NULL,
+    last_used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_passkeys_user_id ON passkeys (user_id);
+
+-- Challenges of registration and login ceremonies that have been started but
+-- not finished. user_id is NULL for logins, the user is only known once the
+-- authenticator answers.
+CREATE TABLE IF NOT EXISTS passkey_ceremonies (
+    id_hash TEXT PRIMARY KEY,
+    ceremony VARCHAR(20) NOT NULL CHECK (ceremony IN ('registration', 'login')),
+    user_id BIGINT REFERENCES users (id) ON DELETE CASCADE,
+    session_data JSONB NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL
+);
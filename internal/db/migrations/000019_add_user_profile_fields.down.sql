@@ -0,0 +1,6 @@
+ALTER TABLE users DROP COLUMN IF EXISTS shared_profile_fields;
+ALTER TABLE users DROP COLUMN IF EXISTS profile_visibility;
+ALTER TABLE users DROP COLUMN IF EXISTS course;
+ALTER TABLE users DROP COLUMN IF EXISTS year_of_study;
+ALTER TABLE users DROP COLUMN IF EXISTS pronouns;
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
@@ -0,0 +1,10 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS pronouns VARCHAR(50) NOT NULL DEFAULT '';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS year_of_study SMALLINT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS course VARCHAR(100) NOT NULL DEFAULT '';
+
+-- Who may see the public profile at all, and which optional fields it shows.
+-- Profiles start out limited to the user's university and share nothing extra.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile_visibility VARCHAR(20) NOT NULL DEFAULT 'university'
+    CHECK (profile_visibility IN ('public', 'university', 'private'));
+ALTER TABLE users ADD COLUMN IF NOT EXISTS shared_profile_fields TEXT[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,2 @@
+ALTER TABLE groups DROP COLUMN IF EXISTS cover_key;
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_key;
@@ -0,0 +1,3 @@
+-- Blob storage keys of uploaded images, the thumbnail lives next to each one
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_key TEXT;
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS cover_key TEXT;
@@ -0,0 +1,5 @@
+-- Accounts are erased once this passes, until then the user can change their mind
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_scheduled_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users (deletion_scheduled_at)
+    WHERE deletion_scheduled_at IS NOT NULL;
@@ -0,0 +1,11 @@
+ALTER TABLE users DROP COLUMN IF EXISTS is_site_admin;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS university VARCHAR(255);
+
+UPDATE users u SET university = (SELECT name FROM universities WHERE id = u.university_id);
+
+ALTER TABLE users ALTER COLUMN university SET NOT NULL;
+DROP INDEX IF EXISTS idx_users_university_id;
+ALTER TABLE users DROP COLUMN IF EXISTS university_id;
+
+DROP TABLE IF EXISTS universities;
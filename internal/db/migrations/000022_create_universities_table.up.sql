@@ -0,0 +1,45 @@
+-- Email domains work as before: subdomains match too, and an empty list lets
+-- any email address claim the university
+CREATE TABLE IF NOT EXISTS universities (
+    id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(255) NOT NULL UNIQUE,
+    country VARCHAR(2) NOT NULL DEFAULT '',
+    email_domains TEXT[] NOT NULL DEFAULT '{}',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_universities_email_domains ON universities USING GIN (email_domains);
+
+INSERT INTO universities (name, country, email_domains, timezone) VALUES
+    ('Brunel University London', 'GB', '{brunel.ac.uk}', 'Europe/London'),
+    ('University of California, Berkeley', 'US', '{berkeley.edu}', 'America/Los_Angeles'),
+    ('Stanford University', 'US', '{stanford.edu}', 'America/Los_Angeles'),
+    ('Massachusetts Institute of Technology', 'US', '{mit.edu}', 'America/New_York'),
+    ('Harvard University', 'US', '{harvard.edu}', 'America/New_York'),
+    ('Yale University', 'US', '{yale.edu}', 'America/New_York'),
+    ('Columbia University', 'US', '{columbia.edu,barnard.edu}', 'America/New_York'),
+    ('Princeton University', 'US', '{princeton.edu}', 'America/New_York'),
+    ('University of Chicago', 'US', '{uchicago.edu}', 'America/Chicago'),
+    ('University of Pennsylvania', 'US', '{upenn.edu}', 'America/New_York'),
+    ('Other', '', '{}', 'UTC')
+ON CONFLICT (name) DO NOTHING;
+
+-- Users now point at their university, anyone whose name did not match a
+-- catalog entry lands in Other
+ALTER TABLE users ADD COLUMN IF NOT EXISTS university_id BIGINT REFERENCES universities (id);
+
+UPDATE users u SET university_id = COALESCE(
+    (SELECT id FROM universities WHERE name = u.university),
+    (SELECT id FROM universities WHERE name = 'Other')
+);
+
+ALTER TABLE users ALTER COLUMN university_id SET NOT NULL;
+ALTER TABLE users DROP COLUMN IF EXISTS university;
+
+CREATE INDEX IF NOT EXISTS idx_users_university_id ON users (university_id);
+
+-- Site administrators manage shared data such as the university catalog. There
+-- is no endpoint to grant it, it is set directly in the database.
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_site_admin BOOLEAN NOT NULL DEFAULT FALSE;
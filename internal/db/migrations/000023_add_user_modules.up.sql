@@ -0,0 +1,2 @@
+-- Module codes the user is taking, shareable like the other profile fields
+ALTER TABLE users ADD COLUMN IF NOT EXISTS modules TEXT[] NOT NULL DEFAULT '{}';
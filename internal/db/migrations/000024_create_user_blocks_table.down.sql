@@ -0,0 +1,2 @@
+ALTER TABLE group_invitations DROP COLUMN IF EXISTS invited_by;
+DROP TABLE IF EXISTS user_blocks;
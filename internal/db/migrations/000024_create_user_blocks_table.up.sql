@@ -0,0 +1,16 @@
+-- A block cuts contact both ways, a mute only quiets the muted user for the
+-- one who muted them. Either can be set independently of the other.
+CREATE TABLE IF NOT EXISTS user_blocks (
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    blocked_user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('block', 'mute')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, blocked_user_id, kind),
+    CHECK (user_id != blocked_user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked_user_id ON user_blocks (blocked_user_id);
+
+-- Who sent an invitation, so invitations from blocked or muted users can be
+-- hidden. Invitations sent before this have no inviter.
+ALTER TABLE group_invitations ADD COLUMN IF NOT EXISTS invited_by BIGINT REFERENCES users (id) ON DELETE SET NULL;
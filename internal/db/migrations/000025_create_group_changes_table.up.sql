@@ -0,0 +1,14 @@
+-- Field level history of group edits. Values are stored as text, NULL meaning
+-- unset (e.g. no member limit). The editor is kept as NULL once their account
+-- is deleted.
+CREATE TABLE IF NOT EXISTS group_changes (
+    id BIGSERIAL PRIMARY KEY,
+    group_id BIGINT NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    user_id BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    field VARCHAR(50) NOT NULL,
+    old_value TEXT,
+    new_value TEXT,
+    changed_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_changes_group_id ON group_changes (group_id, id);
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_membership_group_owner;
+ALTER TABLE membership DROP CONSTRAINT IF EXISTS membership_role_check;
+
+UPDATE membership SET role = 'admin' WHERE role IN ('owner', 'moderator');
+
+ALTER TABLE membership ADD CONSTRAINT membership_role_check CHECK (role IN ('admin', 'member'));
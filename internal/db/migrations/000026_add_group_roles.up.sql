@@ -0,0 +1,18 @@
+-- Admins split into a single owner per group and any number of moderators.
+-- The longest standing admin of each group becomes its owner.
+ALTER TABLE membership DROP CONSTRAINT IF EXISTS membership_role_check;
+
+UPDATE membership SET role = 'moderator' WHERE role = 'admin';
+
+UPDATE membership m SET role = 'owner'
+FROM (
+    SELECT DISTINCT ON (group_id) group_id, user_id
+    FROM membership
+    WHERE role = 'moderator'
+    ORDER BY group_id, joined_at, user_id
+) first_admin
+WHERE m.group_id = first_admin.group_id AND m.user_id = first_admin.user_id;
+
+ALTER TABLE membership ADD CONSTRAINT membership_role_check CHECK (role IN ('owner', 'moderator', 'member'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_membership_group_owner ON membership (group_id) WHERE role = 'owner';
@@ -0,0 +1,11 @@
+-- A pending hand over of a group from its owner to another member, which only
+-- takes effect once the receiver accepts. A group has at most one at a time.
+CREATE TABLE IF NOT EXISTS group_ownership_transfers (
+    group_id BIGINT PRIMARY KEY REFERENCES groups (id) ON DELETE CASCADE,
+    from_user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    to_user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_ownership_transfers_to_user_id ON group_ownership_transfers (to_user_id);
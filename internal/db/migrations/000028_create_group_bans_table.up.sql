@@ -0,0 +1,13 @@
+-- Users banned from a group. A ban without an expiry lasts until it is lifted.
+-- The reason is only shown to the group's owner and moderators.
+CREATE TABLE IF NOT EXISTS group_bans (
+    group_id BIGINT NOT NULL REFERENCES groups (id) ON DELETE CASCADE,
+    user_id BIGINT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    banned_by BIGINT REFERENCES users (id) ON DELETE SET NULL,
+    reason TEXT,
+    expires_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_group_bans_user_id ON group_bans (user_id);
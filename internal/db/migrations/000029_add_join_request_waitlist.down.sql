@@ -0,0 +1,7 @@
+DELETE FROM join_requests WHERE status = 'promoted';
+UPDATE join_requests SET status = 'pending' WHERE status = 'waitlisted';
+
+DROP INDEX IF EXISTS idx_join_requests_promoted;
+DROP INDEX IF EXISTS idx_join_requests_waitlist;
+
+ALTER TABLE join_requests DROP COLUMN IF EXISTS waitlisted_at;
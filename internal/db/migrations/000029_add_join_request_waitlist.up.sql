@@ -0,0 +1,7 @@
+-- Approved join requests wait in line, oldest first, while their group is full.
+-- Once a seat frees up the first in line becomes a member and the request is
+-- kept as 'promoted' until they have been told.
+ALTER TABLE join_requests ADD COLUMN IF NOT EXISTS waitlisted_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS idx_join_requests_waitlist ON join_requests (group_id, waitlisted_at, id) WHERE status = 'waitlisted';
+CREATE INDEX IF NOT EXISTS idx_join_requests_promoted ON join_requests (id) WHERE status = 'promoted';
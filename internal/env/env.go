@@ -3,6 +3,7 @@ package env
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -50,3 +51,20 @@ func GetDuration(key string, fallback time.Duration) time.Duration {
 
 	return value
 }
+
+// GetStrings reads a comma separated list, ignoring empty entries
+func GetStrings(key string, fallback []string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" {
+			values = append(values, value)
+		}
+	}
+
+	if len(values) == 0 {
+		return fallback
+	}
+
+	return values
+}
@@ -0,0 +1,99 @@
+// Package images validates uploaded images and resizes them for storage.
+package images
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"slices"
+
+	// Decoders for the accepted formats
+	_ "image/gif"
+	_ "image/png"
+
+	_ "golang.org/x/image/webp"
+
+	"golang.org/x/image/draw"
+)
+
+// maxPixels bounds the decoded size of an upload, a small file can still
+// claim enormous dimensions and exhaust memory when decoded
+const maxPixels = 40_000_000
+
+var (
+	ErrUnsupportedType = errors.New("image must be a JPEG, PNG, GIF or WebP")
+	ErrTooManyPixels   = errors.New("image dimensions are too large")
+)
+
+// ContentTypes are the accepted upload types, as sniffed from the data
+var ContentTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp"}
+
+// Decode sniffs the content type from the data itself, never trusting the
+// client's claimed type, and decodes the image.
+func Decode(data []byte) (image.Image, error) {
+	if !slices.Contains(ContentTypes, http.DetectContentType(data)) {
+		return nil, ErrUnsupportedType
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, ErrUnsupportedType
+	}
+	if config.Width*config.Height > maxPixels {
+		return nil, ErrTooManyPixels
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedType
+	}
+
+	return img, nil
+}
+
+// Fill scales and center crops the image to exactly width x height
+func Fill(img image.Image, width int, height int) image.Image {
+	bounds := img.Bounds()
+
+	// Largest region of the source with the target aspect ratio
+	crop := bounds
+	if bounds.Dx()*height > bounds.Dy()*width {
+		cropWidth := bounds.Dy() * width / height
+		crop.Min.X += (bounds.Dx() - cropWidth) / 2
+		crop.Max.X = crop.Min.X + cropWidth
+	} else {
+		cropHeight := bounds.Dx() * height / width
+		crop.Min.Y += (bounds.Dy() - cropHeight) / 2
+		crop.Max.Y = crop.Min.Y + cropHeight
+	}
+
+	return scale(img, crop, width, height)
+}
+
+func scale(img image.Image, src image.Rectangle, width int, height int) image.Image {
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, src, draw.Over, nil)
+	return dst
+}
+
+// EncodeJPEG flattens any transparency onto white and encodes the image. Only
+// the first frame of an animated GIF is kept.
+func EncodeJPEG(img image.Image) ([]byte, error) {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	var buf bytes.Buffer
+	err := jpeg.Encode(&buf, flat, &jpeg.Options{Quality: 85})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
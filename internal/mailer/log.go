@@ -0,0 +1,22 @@
+package mailer
+
+import "log"
+
+// LogMailer writes emails to the application log instead of sending them.
+// It is used in development when no SMTP server is configured.
+type LogMailer struct{}
+
+func NewLog() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(templateFile string, email string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("mailer: to=%s subject=%q\n%s", email, subject, body)
+
+	return nil
+}
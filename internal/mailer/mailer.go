@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"strings"
+	"text/template"
+)
+
+const (
+	FromName   = "StudyGroup"
+	maxRetries = 3
+)
+
+// Templates
+const (
+	VerifyEmailTemplate     = "verify_email.tmpl"
+	ResetPasswordTemplate   = "reset_password.tmpl"
+	MagicLinkTemplate       = "magic_link.tmpl"
+	AccountDeletionTemplate = "account_deletion.tmpl"
+	WaitlistJoinedTemplate  = "waitlist_joined.tmpl"
+)
+
+//go:embed templates
+var FS embed.FS
+
+type Client interface {
+	Send(templateFile string, email string, data any) error
+}
+
+// render executes the "subject" and "body" blocks of an embedded template.
+// Line breaks are removed from the subject, it can contain user input such as
+// a group name and must not be able to start a new header.
+func render(templateFile string, data any) (string, string, error) {
+	tmpl, err := template.ParseFS(FS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", err
+	}
+
+	subject := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(subject, "subject", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	body := new(bytes.Buffer)
+	err = tmpl.ExecuteTemplate(body, "body", data)
+	if err != nil {
+		return "", "", err
+	}
+
+	return singleLine(subject.String()), body.String(), nil
+}
+
+// singleLine replaces line breaks with spaces
+func singleLine(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' {
+			return ' '
+		}
+		return r
+	}, s)
+}
@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"mime"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestRenderKeepsSubjectOnOneLine(t *testing.T) {
+	subject, _, err := render(WaitlistJoinedTemplate, map[string]string{
+		"FirstName": "Ada",
+		"GroupName": "Maths\r\nBcc: everyone@example.com",
+		"GroupURL":  "https://studygroup.app/groups/1",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.ContainsAny(subject, "\r\n") {
+		t.Fatalf("subject %q contains a line break", subject)
+	}
+}
+
+func TestMessageEncodesSubject(t *testing.T) {
+	m := NewSMTP("localhost", 25, "", "", "noreply@studygroup.app")
+	subject := "You're in: Café Maths"
+
+	message := m.message("ada@example.com", subject, "Hi Ada")
+
+	header, _, _ := strings.Cut(message, "\r\n\r\n")
+	if strings.ContainsFunc(header, func(r rune) bool { return r > 127 }) {
+		t.Fatalf("header is not ASCII:\n%s", header)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded != subject {
+		t.Fatalf("got subject %q, want %q", decoded, subject)
+	}
+}
@@ -0,0 +1,70 @@
+package mailer
+
+import (
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type SMTPMailer struct {
+	host      string
+	port      int
+	username  string
+	password  string
+	fromEmail string
+}
+
+func NewSMTP(host string, port int, username, password, fromEmail string) *SMTPMailer {
+	return &SMTPMailer{
+		host:      host,
+		port:      port,
+		username:  username,
+		password:  password,
+		fromEmail: fromEmail,
+	}
+}
+
+func (m *SMTPMailer) Send(templateFile string, email string, data any) error {
+	subject, body, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	message := m.message(email, subject, body)
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+
+	// Retry with a linear backoff, mail servers are often briefly unavailable
+	for i := range maxRetries {
+		err = smtp.SendMail(addr, auth, m.fromEmail, []string{email}, []byte(message))
+		if err == nil {
+			return nil
+		}
+
+		time.Sleep(time.Second * time.Duration(i+1))
+	}
+
+	return fmt.Errorf("failed to send email after %d attempts: %w", maxRetries, err)
+}
+
+func (m *SMTPMailer) message(email string, subject string, body string) string {
+	return strings.Join([]string{
+		fmt.Sprintf("From: %s <%s>", FromName, m.fromEmail),
+		"To: " + email,
+		// Headers are ASCII, anything else is sent as an RFC 2047 encoded-word
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject),
+		"MIME-Version: 1.0",
+		`Content-Type: text/plain; charset="UTF-8"`,
+		"",
+		body,
+	}, "\r\n")
+}
@@ -0,0 +1,227 @@
+// Package signing holds the asymmetric keys that sign and verify JWTs. One key
+// signs new tokens while any number of others stay valid for verification, so
+// keys can be rotated without logging anyone out.
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrNoSigningKey = errors.New("signing: no active signing key")
+	ErrUnknownKey   = errors.New("signing: unknown key id")
+)
+
+type Key struct {
+	ID        string
+	Algorithm string
+	public    crypto.PublicKey
+	private   crypto.Signer
+}
+
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+
+	// issuer and audience are stamped on every signed token and required of
+	// every parsed one, when set
+	issuer   string
+	audience string
+}
+
+// SetIssuer names who issues the tokens and who they are meant for
+func (s *KeySet) SetIssuer(issuer string, audience string) {
+	s.issuer = issuer
+	s.audience = audience
+}
+
+// LoadDir loads every key in dir. Private keys are named <kid>.pem (PKCS#8, or
+// PKCS#1 for RSA) and public keys of retired signers <kid>.pub.pem. The key
+// named activeID signs new tokens and must have its private key present.
+func LoadDir(dir string, activeID string) (*KeySet, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &KeySet{keys: make(map[string]*Key)}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".pem") {
+			continue
+		}
+
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		var key *Key
+		if id, ok := strings.CutSuffix(name, ".pub.pem"); ok {
+			key, err = parsePublicKey(id, contents)
+		} else {
+			key, err = parsePrivateKey(strings.TrimSuffix(name, ".pem"), contents)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("signing: loading %s: %w", name, err)
+		}
+
+		// A private key also covers its own public key
+		if existing, ok := set.keys[key.ID]; ok && existing.private != nil {
+			continue
+		}
+		set.keys[key.ID] = key
+	}
+
+	active, ok := set.keys[activeID]
+	if !ok || active.private == nil {
+		return nil, fmt.Errorf("%w: %q", ErrNoSigningKey, activeID)
+	}
+	set.signing = active
+
+	return set, nil
+}
+
+// Ephemeral generates a throwaway Ed25519 key, for development only. Tokens
+// signed with it stop verifying when the process restarts.
+func Ephemeral() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &Key{ID: "ephemeral", Algorithm: jwt.SigningMethodEdDSA.Alg(), public: public, private: private}
+
+	return &KeySet{signing: key, keys: map[string]*Key{key.ID: key}}, nil
+}
+
+// Sign signs the claims with the active key and sets the kid header. Map
+// claims also get the set's iss and aud.
+func (s *KeySet) Sign(claims jwt.Claims) (string, error) {
+	if s.signing == nil {
+		return "", ErrNoSigningKey
+	}
+
+	if mapClaims, ok := claims.(jwt.MapClaims); ok {
+		if s.issuer != "" {
+			mapClaims["iss"] = s.issuer
+		}
+		if s.audience != "" {
+			mapClaims["aud"] = s.audience
+		}
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(s.signing.Algorithm), claims)
+	token.Header["kid"] = s.signing.ID
+
+	return token.SignedString(s.signing.private)
+}
+
+// Parse verifies a token against the key named by its kid header, refusing
+// any algorithm other than the one that key was loaded for, or another issuer
+// or audience than the set's
+func (s *KeySet) Parse(tokenString string) (*jwt.Token, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+	}
+	if s.issuer != "" {
+		options = append(options, jwt.WithIssuer(s.issuer))
+	}
+	if s.audience != "" {
+		options = append(options, jwt.WithAudience(s.audience))
+	}
+
+	return jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		id, _ := token.Header["kid"].(string)
+
+		key, ok := s.keys[id]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("signing: unexpected algorithm %q for key %q", token.Method.Alg(), id)
+		}
+
+		return key.public, nil
+	}, options...)
+}
+
+// Keys returns every verification key sorted by ID
+func (s *KeySet) Keys() []*Key {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, key := range s.keys {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b *Key) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+
+	return keys
+}
+
+func parsePrivateKey(id string, contents []byte) (*Key, error) {
+	block, _ := pem.Decode(contents)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		if private.N.BitLen() < 2048 {
+			return nil, errors.New("RSA keys must be at least 2048 bits")
+		}
+		return &Key{ID: id, Algorithm: jwt.SigningMethodRS256.Alg(), public: &private.PublicKey, private: private}, nil
+	case ed25519.PrivateKey:
+		return &Key{ID: id, Algorithm: jwt.SigningMethodEdDSA.Alg(), public: private.Public(), private: private}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
+
+func parsePublicKey(id string, contents []byte) (*Key, error) {
+	block, _ := pem.Decode(contents)
+	if block == nil || block.Type != "PUBLIC KEY" {
+		return nil, errors.New("no PUBLIC KEY PEM block found")
+	}
+
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	switch public := parsed.(type) {
+	case *rsa.PublicKey:
+		return &Key{ID: id, Algorithm: jwt.SigningMethodRS256.Alg(), public: public}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: id, Algorithm: jwt.SigningMethodEdDSA.Alg(), public: public}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", parsed)
+	}
+}
@@ -0,0 +1,389 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type AccountStore struct {
+	db *sql.DB
+}
+
+// AccountData is everything held about a user's activity in groups, as handed
+// over in a data export
+type AccountData struct {
+	Memberships   []MembershipRecord  `json:"memberships"`
+	JoinRequests  []JoinRequestRecord `json:"join_requests"`
+	Invitations   []InvitationRecord  `json:"invitations"`
+	StudySessions []StudySession      `json:"study_sessions"`
+}
+
+type MembershipRecord struct {
+	GroupID   int       `json:"group_id"`
+	GroupName string    `json:"group_name"`
+	Role      string    `json:"role"`
+	JoinedAt  time.Time `json:"joined_at"`
+}
+
+type JoinRequestRecord struct {
+	GroupID   int    `json:"group_id"`
+	GroupName string `json:"group_name"`
+	Status    string `json:"status"`
+}
+
+type InvitationRecord struct {
+	GroupID   int       `json:"group_id"`
+	GroupName string    `json:"group_name"`
+	InvitedAt time.Time `json:"invited_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ExportAccountData collects the user's group activity from a single snapshot
+// of the database, expired invitations and past study sessions included
+func (s *AccountStore) ExportAccountData(ctx context.Context, userID int) (AccountData, error) {
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return AccountData{}, err
+	}
+	defer tx.Rollback()
+
+	data := AccountData{
+		Memberships:   []MembershipRecord{},
+		JoinRequests:  []JoinRequestRecord{},
+		Invitations:   []InvitationRecord{},
+		StudySessions: []StudySession{},
+	}
+
+	query := `
+		SELECT g.id, g.name, m.role, m.joined_at
+		FROM membership m
+		JOIN groups g ON g.id = m.group_id
+		WHERE m.user_id = $1
+		ORDER BY m.joined_at
+	`
+	err = collectRows(ctx, tx, query, userID, func(rows *sql.Rows) error {
+		var record MembershipRecord
+		err := rows.Scan(&record.GroupID, &record.GroupName, &record.Role, &record.JoinedAt)
+		data.Memberships = append(data.Memberships, record)
+		return err
+	})
+	if err != nil {
+		return AccountData{}, err
+	}
+
+	query = `
+		SELECT g.id, g.name, r.status
+		FROM join_requests r
+		JOIN groups g ON g.id = r.group_id
+		WHERE r.user_id = $1
+		ORDER BY r.id
+	`
+	err = collectRows(ctx, tx, query, userID, func(rows *sql.Rows) error {
+		var record JoinRequestRecord
+		err := rows.Scan(&record.GroupID, &record.GroupName, &record.Status)
+		data.JoinRequests = append(data.JoinRequests, record)
+		return err
+	})
+	if err != nil {
+		return AccountData{}, err
+	}
+
+	query = `
+		SELECT g.id, g.name, i.invited_at, i.expires_at
+		FROM group_invitations i
+		JOIN groups g ON g.id = i.group_id
+		WHERE i.user_id = $1
+		ORDER BY i.invited_at
+	`
+	err = collectRows(ctx, tx, query, userID, func(rows *sql.Rows) error {
+		var record InvitationRecord
+		err := rows.Scan(&record.GroupID, &record.GroupName, &record.InvitedAt, &record.ExpiresAt)
+		data.Invitations = append(data.Invitations, record)
+		return err
+	})
+	if err != nil {
+		return AccountData{}, err
+	}
+
+	query = `
+		SELECT s.id, s.group_id, s.title, s.description, s.location, s.start_time, s.end_time, s.created_at
+		FROM study_sessions s
+		JOIN membership m ON m.group_id = s.group_id
+		WHERE m.user_id = $1
+		ORDER BY s.start_time
+	`
+	err = collectRows(ctx, tx, query, userID, func(rows *sql.Rows) error {
+		var session StudySession
+		err := rows.Scan(&session.ID, &session.GroupID, &session.Title, &session.Description, &session.Location, &session.StartTime, &session.EndTime, &session.CreatedAt)
+		data.StudySessions = append(data.StudySessions, session)
+		return err
+	})
+	if err != nil {
+		return AccountData{}, err
+	}
+
+	return data, tx.Commit()
+}
+
+// collectRows runs a query taking the user id and calls scan for every row
+func collectRows(ctx context.Context, tx *sql.Tx, query string, userID int, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		err := scan(rows)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// GetDeletionSchedule returns when the user's account will be erased, or nil
+// when no deletion is pending
+func (s *AccountStore) GetDeletionSchedule(ctx context.Context, userID int) (*time.Time, error) {
+	query := `
+		SELECT deletion_scheduled_at FROM users WHERE id = $1
+	`
+
+	var scheduledAt *time.Time
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&scheduledAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNoRows
+		default:
+			return nil, err
+		}
+	}
+
+	return scheduledAt, nil
+}
+
+// ScheduleDeletion marks the account for erasure at the given time. Asking
+// again while a deletion is pending keeps the original date.
+func (s *AccountStore) ScheduleDeletion(ctx context.Context, userID int, at time.Time) (time.Time, error) {
+	query := `
+		UPDATE users SET deletion_scheduled_at = COALESCE(deletion_scheduled_at, $2), updated_at = NOW()
+		WHERE id = $1
+		RETURNING deletion_scheduled_at
+	`
+
+	var scheduledAt time.Time
+	err := s.db.QueryRowContext(ctx, query, userID, at).Scan(&scheduledAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return time.Time{}, ErrNoRows
+		default:
+			return time.Time{}, err
+		}
+	}
+
+	return scheduledAt, nil
+}
+
+// CancelDeletion keeps the account, returning ErrNotFound when no deletion was
+// pending
+func (s *AccountStore) CancelDeletion(ctx context.Context, userID int) error {
+	query := `
+		UPDATE users SET deletion_scheduled_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND deletion_scheduled_at IS NOT NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// GetAccountsDueForDeletion lists up to limit accounts whose grace period ended
+// before the given time
+func (s *AccountStore) GetAccountsDueForDeletion(ctx context.Context, before time.Time, limit int) ([]int, error) {
+	query := `
+		SELECT id FROM users
+		WHERE deletion_scheduled_at <= $1
+		ORDER BY deletion_scheduled_at
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var userIDs []int
+	for rows.Next() {
+		var userID int
+		err := rows.Scan(&userID)
+		if err != nil {
+			return nil, err
+		}
+
+		userIDs = append(userIDs, userID)
+	}
+
+	return userIDs, rows.Err()
+}
+
+// DeleteAccount erases a user whose deletion is due. Their seat in each group
+// goes to the waitlist. Groups they own are handed to a moderator, or failing
+// that their longest standing member, and deleted when nobody else is left.
+// Each group is locked first, like leaving or banning does, so members cannot
+// leave while it is handed over. Every other row about the user goes with them
+// through the foreign keys, and login attempts, which are only tied to the
+// email, are removed too.
+//
+// It returns the blob keys of the images that were deleted along the way. A
+// deletion that was cancelled in the meantime returns ErrNotFound.
+func (s *AccountStore) DeleteAccount(ctx context.Context, userID int) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT email, COALESCE(avatar_key, '')
+		FROM users
+		WHERE id = $1 AND deletion_scheduled_at <= NOW()
+		FOR UPDATE
+	`
+
+	var email, avatarKey string
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&email, &avatarKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	var imageKeys []string
+	if avatarKey != "" {
+		imageKeys = append(imageKeys, avatarKey)
+	}
+
+	// Groups are locked in order of id so two deletions cannot deadlock
+	query = `
+		SELECT group_id FROM membership WHERE user_id = $1 ORDER BY group_id
+	`
+	var groupIDs []int
+	err = collectRows(ctx, tx, query, userID, func(rows *sql.Rows) error {
+		var groupID int
+		err := rows.Scan(&groupID)
+		groupIDs = append(groupIDs, groupID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, groupID := range groupIDs {
+		_, err = lockGroupSeats(ctx, tx, groupID)
+		if err != nil {
+			// Deleted in the meantime
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return nil, err
+		}
+
+		// The role is read under the lock, an ownership transfer may have
+		// finished since the groups were listed. A group has one owner at a
+		// time, so the old one goes first.
+		query = `
+			DELETE FROM membership WHERE group_id = $1 AND user_id = $2 RETURNING role
+		`
+		var role string
+		err = tx.QueryRowContext(ctx, query, groupID, userID).Scan(&role)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				continue
+			}
+			return nil, err
+		}
+
+		if role != RoleOwner {
+			err = fillFromWaitlist(ctx, tx, groupID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		query = `
+			UPDATE membership SET role = 'owner'
+			WHERE (user_id, group_id) = (
+				SELECT user_id, group_id FROM membership
+				WHERE group_id = $1
+				ORDER BY role = 'moderator' DESC, joined_at, user_id
+				LIMIT 1
+			)
+		`
+		result, err := tx.ExecContext(ctx, query, groupID)
+		if err != nil {
+			return nil, err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if rowsAffected > 0 {
+			err = fillFromWaitlist(ctx, tx, groupID)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		query = `
+			DELETE FROM groups WHERE id = $1 RETURNING COALESCE(cover_key, '')
+		`
+		var coverKey string
+		err = tx.QueryRowContext(ctx, query, groupID).Scan(&coverKey)
+		if err != nil {
+			return nil, err
+		}
+		if coverKey != "" {
+			imageKeys = append(imageKeys, coverKey)
+		}
+	}
+
+	for _, query := range []string{
+		`DELETE FROM login_attempts WHERE email = $1`,
+		`DELETE FROM login_lockouts WHERE email = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, email)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return imageKeys, tx.Commit()
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDeleteAccountHandsGroupOverWhileMembersLeave(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	for range 10 {
+		groupID, ownerID := createTestGroup(t, s, 0)
+		leaving := addTestMember(t, s, groupID)
+		staying := addTestMember(t, s, groupID)
+
+		_, err := s.Account.ScheduleDeletion(ctx, ownerID, time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := s.Account.DeleteAccount(ctx, ownerID)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// The leaving member may have been made owner first
+			err := s.GroupMembershipManagement.LeaveGroup(ctx, groupID, leaving)
+			if err != nil && !errors.Is(err, ErrConflict) {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		if t.Failed() {
+			return
+		}
+
+		// Whoever got the group, it must not have been deleted from under
+		// the member who stayed
+		role, err := s.GroupMembership.GetRole(ctx, groupID, staying)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role == "" {
+			t.Fatal("the member who stayed lost the group")
+		}
+
+		leaverRole, err := s.GroupMembership.GetRole(ctx, groupID, leaving)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (role == RoleOwner) == (leaverRole == RoleOwner) {
+			t.Fatalf("want exactly one owner, got roles %q and %q", role, leaverRole)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key so Authenticate can tell keys from JWTs and
+// leaked keys are easy to spot
+const APIKeyPrefix = "sgk_"
+
+// Scopes an API key can be granted
+const (
+	ScopeUserRead      = "user:read"
+	ScopeUserWrite     = "user:write"
+	ScopeGroupsRead    = "groups:read"
+	ScopeGroupsWrite   = "groups:write"
+	ScopeSessionsRead  = "sessions:read"
+	ScopeSessionsWrite = "sessions:write"
+)
+
+// Scopes lists every scope an API key can be granted
+var Scopes = []string{
+	ScopeUserRead,
+	ScopeUserWrite,
+	ScopeGroupsRead,
+	ScopeGroupsWrite,
+	ScopeSessionsRead,
+	ScopeSessionsWrite,
+}
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"-"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// HasScope reports whether the key was granted the scope
+func (k APIKey) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// CreateAPIKey mints a key for the user. Only its hash is stored, the raw key
+// is returned so it can be shown to the user once.
+func (s *APIKeyStore) CreateAPIKey(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (APIKey, string, error) {
+	key := APIKeyPrefix + rand.Text()
+
+	apiKey := APIKey{
+		UserID:    userID,
+		Name:      name,
+		Prefix:    key[:len(APIKeyPrefix)+8],
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	}
+
+	query := `
+		INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`
+
+	err := s.db.QueryRowContext(ctx, query, userID, name, apiKey.Prefix, hashToken(key), pq.Array(scopes), expiresAt).Scan(&apiKey.ID, &apiKey.CreatedAt)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// ListAPIKeys returns the user's keys that have not been revoked, newest first
+func (s *APIKeyStore) ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	apiKeys := []APIKey{}
+	for rows.Next() {
+		var apiKey APIKey
+		err := rows.Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		apiKeys = append(apiKeys, apiKey)
+	}
+
+	return apiKeys, rows.Err()
+}
+
+// GetAPIKey looks up an active key by its raw value. Unknown, expired and
+// revoked keys all return ErrNotFound.
+func (s *APIKeyStore) GetAPIKey(ctx context.Context, key string) (APIKey, error) {
+	query := `
+		SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at
+		FROM api_keys
+		WHERE key_hash = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > NOW())
+	`
+
+	var apiKey APIKey
+	err := s.db.QueryRowContext(ctx, query, hashToken(key)).Scan(&apiKey.ID, &apiKey.UserID, &apiKey.Name, &apiKey.Prefix, pq.Array(&apiKey.Scopes), &apiKey.ExpiresAt, &apiKey.LastUsedAt, &apiKey.CreatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return APIKey{}, ErrNotFound
+		default:
+			return APIKey{}, err
+		}
+	}
+
+	return apiKey, nil
+}
+
+// TouchAPIKey records that the key was used. Writes are limited to one a
+// minute, like session activity.
+func (s *APIKeyStore) TouchAPIKey(ctx context.Context, keyID int) error {
+	query := `
+		UPDATE api_keys SET last_used_at = NOW()
+		WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL '1 minute')
+	`
+
+	_, err := s.db.ExecContext(ctx, query, keyID)
+	return err
+}
+
+func (s *APIKeyStore) RevokeAPIKey(ctx context.Context, userID int, keyID int) error {
+	query := `
+		UPDATE api_keys SET revoked_at = NOW()
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, keyID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -2,11 +2,16 @@ package store
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"strconv"
 	"time"
 
+	"github.com/RakibulBh/studygroup-backend/internal/signing"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -14,22 +19,55 @@ type AuthStore struct {
 	db *sql.DB
 }
 
+// Device describes the client a refresh token was issued to
+type Device struct {
+	UserAgent string
+	IP        string
+}
+
+// AuthSession is one signed in device, i.e. one refresh token family
+type AuthSession struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
 type RegisterRequest struct {
 	FirstName    string `json:"first_name"`
 	LastName     string `json:"last_name"`
 	Email        string `json:"email"`
-	University   string `json:"university"`
+	UniversityID int    `json:"university_id"`
 	PasswordHash string `json:"password_hash"`
 }
 
-func (s *AuthStore) Register(ctx context.Context, request RegisterRequest) error {
+func (s *AuthStore) Register(ctx context.Context, request RegisterRequest) (int, error) {
 
 	query := `
-		INSERT INTO users (first_name, last_name, email, university, password_hash)
+		INSERT INTO users (first_name, last_name, email, university_id, password_hash)
 		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
 	`
 
-	_, err := s.db.ExecContext(ctx, query, request.FirstName, request.LastName, request.Email, request.University, request.PasswordHash)
+	var id int
+	err := s.db.QueryRowContext(ctx, query, request.FirstName, request.LastName, request.Email, request.UniversityID, request.PasswordHash).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (s *AuthStore) VerifyEmail(ctx context.Context, userID int) error {
+	query := `
+		UPDATE users SET email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email_verified_at IS NULL
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID)
 	if err != nil {
 		return err
 	}
@@ -37,13 +75,16 @@ func (s *AuthStore) Register(ctx context.Context, request RegisterRequest) error
 	return nil
 }
 
-func (s *AuthStore) StoreRefreshToken(ctx context.Context, userID int, token string, expiresAt time.Time) error {
+// StoreRefreshToken persists the hash of a refresh token as part of the given
+// token family. A new login starts a new family, which is listed to the user
+// as one signed in device.
+func (s *AuthStore) StoreRefreshToken(ctx context.Context, userID int, familyID string, token string, expiresAt time.Time, device Device) error {
 	query := `
-		INSERT INTO refresh_tokens (user_id, token, expires_at)
-		VALUES ($1, $2, $3)
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
 	`
 
-	_, err := s.db.ExecContext(ctx, query, userID, token, expiresAt)
+	_, err := s.db.ExecContext(ctx, query, userID, familyID, hashToken(token), expiresAt, device.UserAgent, device.IP)
 	if err != nil {
 		return err
 	}
@@ -63,11 +104,16 @@ func (s *AuthStore) HashPassword(password string) (string, error) {
 
 func (s *AuthStore) VerifyPassword(password string, hash string) (bool, error) {
 
+	// Accounts created through a login provider have no password
+	if hash == "" {
+		return false, nil
+	}
+
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	if err != nil {
 		switch err {
 		case bcrypt.ErrMismatchedHashAndPassword:
-			return false, errors.New("invalid credentials")
+			return false, nil
 		default:
 			return false, err
 		}
@@ -76,13 +122,27 @@ func (s *AuthStore) VerifyPassword(password string, hash string) (bool, error) {
 	return true, nil
 }
 
-func (s *AuthStore) GenerateJWT(userID int, expiresAt time.Time, secret string) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+// Token types, carried in the typ claim so a refresh token can never pass as
+// an access token or the other way round
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// GenerateJWT signs an access or refresh token for the user. sid is the
+// refresh token family the token belongs to, so revoking the session also
+// invalidates its access tokens.
+func (s *AuthStore) GenerateJWT(userID int, sessionID string, tokenType string, expiresAt time.Time, keys *signing.KeySet) (string, error) {
+	// iat keeps microsecond precision so a token minted straight after a
+	// revocation is not mistaken for one issued before it
+	tokenString, err := keys.Sign(jwt.MapClaims{
+		"typ":     tokenType,
 		"user_id": userID,
+		"sid":     sessionID,
+		"jti":     uuid.NewString(),
+		"iat":     float64(time.Now().UnixMicro()) / 1e6,
 		"exp":     expiresAt.Unix(),
 	})
-
-	tokenString, err := token.SignedString([]byte(secret))
 	if err != nil {
 		return "", err
 	}
@@ -90,10 +150,8 @@ func (s *AuthStore) GenerateJWT(userID int, expiresAt time.Time, secret string)
 	return tokenString, nil
 }
 
-func (s *AuthStore) VerifyToken(tokenString string, secret string) (*jwt.Token, error) {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
-		return []byte(secret), nil
-	})
+func (s *AuthStore) VerifyToken(tokenString string, keys *signing.KeySet) (*jwt.Token, error) {
+	token, err := keys.Parse(tokenString)
 
 	if err != nil {
 		return nil, err
@@ -106,35 +164,321 @@ func (s *AuthStore) VerifyToken(tokenString string, secret string) (*jwt.Token,
 	return token, nil
 }
 
-func (s *AuthStore) RefreshToken(ctx context.Context, userID int, tokenString string, secret string, refreshExp time.Duration, accessExp time.Duration) (string, string, error) {
+// GenerateActionToken signs a short lived token that authorises a single kind
+// of action, such as verifying an email address. It deliberately has no
+// user_id claim so it can never be used as an access token.
+func (s *AuthStore) GenerateActionToken(userID int, action string, expiresAt time.Time, keys *signing.KeySet) (string, error) {
+	tokenString, err := keys.Sign(jwt.MapClaims{
+		"sub":    strconv.Itoa(userID),
+		"action": action,
+		"jti":    uuid.NewString(),
+		"iat":    time.Now().Unix(),
+		"exp":    expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return tokenString, nil
+}
+
+// VerifyActionToken checks an action token's signature, expiry and action and
+// returns the user it was issued for.
+func (s *AuthStore) VerifyActionToken(tokenString string, action string, keys *signing.KeySet) (int, error) {
+	token, err := s.VerifyToken(tokenString, keys)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["action"] != action {
+		return 0, ErrInvalidToken
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	return userID, nil
+}
+
+// RefreshToken rotates a refresh token within its family. Presenting a token
+// that was already rotated means it has been copied, so the whole family is
+// revoked and ErrTokenReused is returned.
+func (s *AuthStore) RefreshToken(ctx context.Context, userID int, tokenString string, keys *signing.KeySet, refreshExp time.Duration, accessExp time.Duration, device Device) (string, string, error) {
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", "", err
+	}
+	defer tx.Rollback()
 
-	// Delete the old refresh token
+	// Lock the presented token so concurrent refreshes are serialised
 	query := `
-		DELETE FROM refresh_tokens
-		WHERE token = $1 AND user_id = $2
+		SELECT id, family_id, expires_at, revoked_at, rotated_at
+		FROM refresh_tokens
+		WHERE token_hash = $1 AND user_id = $2
+		FOR UPDATE
+	`
+
+	var id int
+	var familyID string
+	var expiresAt time.Time
+	var revokedAt, rotatedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, query, hashToken(tokenString), userID).Scan(&id, &familyID, &expiresAt, &revokedAt, &rotatedAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", "", ErrInvalidToken
+		default:
+			return "", "", err
+		}
+	}
+
+	if rotatedAt.Valid {
+		err = revokeFamily(ctx, tx, familyID)
+		if err != nil {
+			return "", "", err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return "", "", err
+		}
+
+		return "", "", ErrTokenReused
+	}
+
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return "", "", ErrInvalidToken
+	}
+
+	// Retire the old refresh token
+	query = `
+		UPDATE refresh_tokens SET rotated_at = NOW(), revoked_at = NOW() WHERE id = $1
 	`
-	_, err := s.db.ExecContext(ctx, query, tokenString, userID)
+	_, err = tx.ExecContext(ctx, query, id)
 	if err != nil {
 		return "", "", err
 	}
 
 	// Generate a new refresh token
-	refreshToken, err := s.GenerateJWT(userID, time.Now().Add(refreshExp), secret)
+	refreshToken, err := s.GenerateJWT(userID, familyID, TokenTypeRefresh, time.Now().Add(refreshExp), keys)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Store the new refresh token in the same family with the latest device details
+	query = `
+		INSERT INTO refresh_tokens (user_id, family_id, token_hash, expires_at, user_agent, ip)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`
+	_, err = tx.ExecContext(ctx, query, userID, familyID, hashToken(refreshToken), time.Now().Add(refreshExp), device.UserAgent, device.IP)
 	if err != nil {
 		return "", "", err
 	}
 
-	// Store the new refresh token
-	err = s.StoreRefreshToken(ctx, userID, refreshToken, time.Now().Add(refreshExp))
+	err = tx.Commit()
 	if err != nil {
 		return "", "", err
 	}
 
 	// Generate a new access token
-	tokenString, err = s.GenerateJWT(userID, time.Now().Add(accessExp), secret)
+	tokenString, err = s.GenerateJWT(userID, familyID, TokenTypeAccess, time.Now().Add(accessExp), keys)
 	if err != nil {
 		return "", "", err
 	}
 
 	return tokenString, refreshToken, nil
 }
+
+// RevokeRefreshToken ends the session the token belongs to by revoking its
+// whole family. Only the current token of a family is accepted.
+func (s *AuthStore) RevokeRefreshToken(ctx context.Context, userID int, tokenString string) error {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE revoked_at IS NULL AND family_id = (
+			SELECT family_id FROM refresh_tokens
+			WHERE token_hash = $1 AND user_id = $2 AND revoked_at IS NULL
+		)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, hashToken(tokenString), userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+// RevokeAllTokens revokes every refresh token of the user and invalidates all
+// access tokens issued up to now.
+func (s *AuthStore) RevokeAllTokens(ctx context.Context, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = revokeAllTokens(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsTokenRevoked reports whether a token of the session, issued at issuedAt,
+// has been revoked: either the session was signed out or the token predates
+// the user's last "log out everywhere".
+func (s *AuthStore) IsTokenRevoked(ctx context.Context, userID int, sessionID string, issuedAt time.Time) (bool, error) {
+	query := `
+		SELECT u.tokens_revoked_at, EXISTS(
+			SELECT 1 FROM refresh_tokens t
+			WHERE t.user_id = u.id AND t.family_id = $2 AND t.revoked_at IS NULL
+		)
+		FROM users u
+		WHERE u.id = $1
+	`
+
+	var revokedAt sql.NullTime
+	var sessionActive bool
+	err := s.db.QueryRowContext(ctx, query, userID, sessionID).Scan(&revokedAt, &sessionActive)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return true, ErrNoRows
+		default:
+			return true, err
+		}
+	}
+
+	if !sessionActive {
+		return true, nil
+	}
+
+	if !revokedAt.Valid {
+		return false, nil
+	}
+
+	return !issuedAt.After(revokedAt.Time), nil
+}
+
+// ListSessions returns the user's signed in devices, most recently used first
+func (s *AuthStore) ListSessions(ctx context.Context, userID int) ([]AuthSession, error) {
+	query := `
+		SELECT t.family_id, t.user_agent, t.ip, f.started_at, t.last_used_at, t.expires_at
+		FROM refresh_tokens t
+		JOIN (
+			SELECT family_id, MIN(created_at) AS started_at
+			FROM refresh_tokens
+			WHERE user_id = $1
+			GROUP BY family_id
+		) f ON f.family_id = t.family_id
+		WHERE t.user_id = $1 AND t.revoked_at IS NULL AND t.expires_at > NOW()
+		ORDER BY t.last_used_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []AuthSession{}
+	for rows.Next() {
+		var session AuthSession
+		err := rows.Scan(&session.ID, &session.UserAgent, &session.IP, &session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeSession signs a single device out
+func (s *AuthStore) RevokeSession(ctx context.Context, userID int, sessionID string) error {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND family_id = $2 AND revoked_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, sessionID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// TouchSession bumps the session's last used time. Writes are limited to one a
+// minute so busy clients do not update the row on every request.
+func (s *AuthStore) TouchSession(ctx context.Context, sessionID string, device Device) error {
+	query := `
+		UPDATE refresh_tokens SET last_used_at = NOW(), user_agent = $2, ip = $3
+		WHERE family_id = $1 AND revoked_at IS NULL AND last_used_at < NOW() - INTERVAL '1 minute'
+	`
+
+	_, err := s.db.ExecContext(ctx, query, sessionID, device.UserAgent, device.IP)
+	return err
+}
+
+func revokeFamily(ctx context.Context, tx *sql.Tx, familyID string) error {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE family_id = $1 AND revoked_at IS NULL
+	`
+
+	_, err := tx.ExecContext(ctx, query, familyID)
+	return err
+}
+
+// hashToken returns the hex encoded SHA-256 of a token. Tokens carry enough
+// entropy that a fast unsalted hash is sufficient for lookups.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func revokeAllTokens(ctx context.Context, tx *sql.Tx, userID int) error {
+	query := `
+		UPDATE refresh_tokens SET revoked_at = NOW()
+		WHERE user_id = $1 AND revoked_at IS NULL
+	`
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	// Use the application clock, the same one that stamps iat on tokens
+	query = `
+		UPDATE users SET tokens_revoked_at = $1 WHERE id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, time.Now(), userID)
+	return err
+}
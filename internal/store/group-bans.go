@@ -0,0 +1,172 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// GroupBanStore keeps users out of groups. A banned user is taken out of the
+// group, loses their pending join request and invitation, and cannot ask to
+// join or be invited again until the ban expires or is lifted.
+type GroupBanStore struct {
+	db *sql.DB
+}
+
+type GroupBan struct {
+	GroupID   int        `json:"group_id"`
+	UserID    int        `json:"user_id"`
+	FirstName string     `json:"first_name"`
+	LastName  string     `json:"last_name"`
+	BannedBy  *int       `json:"banned_by"`
+	Reason    string     `json:"reason"`
+	ExpiresAt *time.Time `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// activeBan matches the bans that are still in force, for a group_bans row
+// aliased as b
+const activeBan = `(b.expires_at IS NULL OR b.expires_at > NOW())`
+
+// BanUser bans a user from the group on behalf of someone holding byRole,
+// replacing any earlier ban. A member must be outranked by the banner or
+// ErrConflict is returned. Users who are not members can be banned too, an
+// unknown user returns ErrNotFound.
+func (s *GroupBanStore) BanUser(ctx context.Context, ban *GroupBan, byRole string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockGroupSeats(ctx, tx, ban.GroupID)
+	if err != nil {
+		return err
+	}
+
+	role, err := lockRole(ctx, tx, ban.GroupID, ban.UserID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	if !Outranks(byRole, role) {
+		return ErrConflict
+	}
+
+	if role != "" {
+		err = deleteMembership(ctx, tx, ban.GroupID, ban.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, query := range []string{
+		`DELETE FROM join_requests WHERE group_id = $1 AND user_id = $2`,
+		`DELETE FROM group_invitations WHERE group_id = $1 AND user_id = $2`,
+	} {
+		_, err = tx.ExecContext(ctx, query, ban.GroupID, ban.UserID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query := `
+		INSERT INTO group_bans (group_id, user_id, banned_by, reason, expires_at)
+		VALUES ($1, $2, $3, NULLIF($4, ''), $5)
+		ON CONFLICT (group_id, user_id) DO UPDATE
+		SET banned_by = EXCLUDED.banned_by,
+			reason = EXCLUDED.reason,
+			expires_at = EXCLUDED.expires_at,
+			created_at = NOW()
+		RETURNING created_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, ban.GroupID, ban.UserID, ban.BannedBy, ban.Reason, ban.ExpiresAt).Scan(&ban.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Unban lifts a ban, returning ErrNotFound when the user was not banned
+func (s *GroupBanStore) Unban(ctx context.Context, groupID int, userID int) error {
+	query := `
+		DELETE FROM group_bans b WHERE b.group_id = $1 AND b.user_id = $2 AND ` + activeBan + `
+	`
+
+	result, err := s.db.ExecContext(ctx, query, groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// ListBans returns the group's bans that are still in force, newest first
+func (s *GroupBanStore) ListBans(ctx context.Context, groupID int) ([]GroupBan, error) {
+	query := `
+		SELECT b.group_id, b.user_id, u.first_name, u.last_name, b.banned_by, COALESCE(b.reason, ''), b.expires_at, b.created_at
+		FROM group_bans b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.group_id = $1 AND ` + activeBan + `
+		ORDER BY b.created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, groupID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []GroupBan{}
+	for rows.Next() {
+		var ban GroupBan
+		var bannedBy sql.NullInt64
+		var expiresAt sql.NullTime
+		err := rows.Scan(&ban.GroupID, &ban.UserID, &ban.FirstName, &ban.LastName, &bannedBy, &ban.Reason, &expiresAt, &ban.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if bannedBy.Valid {
+			id := int(bannedBy.Int64)
+			ban.BannedBy = &id
+		}
+		if expiresAt.Valid {
+			ban.ExpiresAt = &expiresAt.Time
+		}
+
+		bans = append(bans, ban)
+	}
+
+	return bans, rows.Err()
+}
+
+// IsBanned reports whether the user is currently banned from the group
+func (s *GroupBanStore) IsBanned(ctx context.Context, groupID int, userID int) (bool, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM group_bans b WHERE b.group_id = $1 AND b.user_id = $2 AND ` + activeBan + `)
+	`
+
+	var banned bool
+	err := s.db.QueryRowContext(ctx, query, groupID, userID).Scan(&banned)
+	if err != nil {
+		return false, err
+	}
+
+	return banned, nil
+}
@@ -0,0 +1,48 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestBanRacingJoinRequestLeavesNoRequest(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	for range 10 {
+		groupID, ownerID := createTestGroup(t, s, 0)
+		userID := createTestUser(t, s)
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := s.GroupBans.BanUser(ctx, &GroupBan{GroupID: groupID, UserID: userID, BannedBy: &ownerID}, RoleOwner)
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			err := s.GroupJoinRequests.JoinRequest(ctx, groupID, userID)
+			if err != nil && !errors.Is(err, ErrBanned) {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		if t.Failed() {
+			return
+		}
+
+		joinRequests, err := s.GroupJoinRequests.GetJoinRequests(ctx, groupID, ownerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(joinRequests) != 0 {
+			t.Fatalf("a banned user still has join requests %+v", joinRequests)
+		}
+	}
+}
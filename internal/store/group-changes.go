@@ -0,0 +1,109 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// Fields tracked in a group's change history
+const (
+	GroupFieldName        = "name"
+	GroupFieldDescription = "description"
+	GroupFieldSubject     = "subject"
+	GroupFieldLocation    = "location"
+	GroupFieldVisibility  = "visibility"
+	GroupFieldMemberLimit = "member_limit"
+)
+
+var GroupFields = []string{
+	GroupFieldName,
+	GroupFieldDescription,
+	GroupFieldSubject,
+	GroupFieldLocation,
+	GroupFieldVisibility,
+	GroupFieldMemberLimit,
+}
+
+// GroupChange records one field of a group being edited. Values are nil when
+// unset, such as a group without a member limit, and UserID is nil once the
+// editor's account is gone.
+type GroupChange struct {
+	ID        int       `json:"id"`
+	GroupID   int       `json:"group_id"`
+	UserID    *int      `json:"user_id"`
+	Field     string    `json:"field"`
+	OldValue  *string   `json:"old_value"`
+	NewValue  *string   `json:"new_value"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// diffGroups lists the fields that differ between two versions of a group
+func diffGroups(old Group, updated Group) []GroupChange {
+	changes := []GroupChange{}
+
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{GroupFieldName, old.Name, updated.Name},
+		{GroupFieldDescription, old.Description, updated.Description},
+		{GroupFieldSubject, old.Subject, updated.Subject},
+		{GroupFieldLocation, old.Location, updated.Location},
+		{GroupFieldVisibility, old.Visibility, updated.Visibility},
+	} {
+		if field.old != field.new {
+			changes = append(changes, GroupChange{Field: field.name, OldValue: &field.old, NewValue: &field.new})
+		}
+	}
+
+	oldLimit, newLimit := memberLimitValue(old), memberLimitValue(updated)
+	if (oldLimit == nil) != (newLimit == nil) || (oldLimit != nil && *oldLimit != *newLimit) {
+		changes = append(changes, GroupChange{Field: GroupFieldMemberLimit, OldValue: oldLimit, NewValue: newLimit})
+	}
+
+	return changes
+}
+
+func memberLimitValue(group Group) *string {
+	if !group.HasMemberLimit {
+		return nil
+	}
+
+	value := strconv.Itoa(group.MemberLimit)
+	return &value
+}
+
+// GetGroupChanges lists a group's history newest first, optionally only for
+// one field. Pages continue from the id of the last change seen, pass 0 for
+// the first page.
+func (s *GroupRepository) GetGroupChanges(ctx context.Context, groupID int, field string, beforeID int, limit int) ([]GroupChange, error) {
+	query := `
+		SELECT id, group_id, user_id, field, old_value, new_value, changed_at
+		FROM group_changes
+		WHERE group_id = $1
+			AND ($2 = '' OR field = $2)
+			AND ($3 = 0 OR id < $3)
+		ORDER BY id DESC
+		LIMIT $4
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, groupID, field, beforeID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	changes := []GroupChange{}
+	for rows.Next() {
+		var change GroupChange
+		err := rows.Scan(&change.ID, &change.GroupID, &change.UserID, &change.Field, &change.OldValue, &change.NewValue, &change.ChangedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
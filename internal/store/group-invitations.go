@@ -18,11 +18,39 @@ type GroupInvitationsStore struct {
 	db *sql.DB
 }
 
-func (s *GroupInvitationsStore) InviteUserToGroup(ctx context.Context, groupID int, userID int) error {
+// InviteUserToGroup replaces any invitation the user has to the group with a
+// new one from invitedBy. Users who blocked each other cannot invite one
+// another, which returns ErrBlocked, and users banned from the group cannot be
+// invited, which returns ErrBanned. The ban is checked under the group lock
+// BanUser takes, so a ban cannot land between the check and the invitation.
+func (s *GroupInvitationsStore) InviteUserToGroup(ctx context.Context, groupID int, userID int, invitedBy int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+
 	query := `
+		SELECT EXISTS(SELECT 1 FROM group_bans b WHERE b.group_id = $1 AND b.user_id = $2 AND ` + activeBan + `)
+	`
+	var banned bool
+	err = tx.QueryRowContext(ctx, query, groupID, userID).Scan(&banned)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+
+	query = `
 		DELETE FROM group_invitations WHERE user_id = $1 AND group_id = $2
 	`
-	_, err := s.db.ExecContext(ctx, query, userID, groupID)
+	_, err = tx.ExecContext(ctx, query, userID, groupID)
 	if err != nil {
 		return err
 	}
@@ -30,56 +58,99 @@ func (s *GroupInvitationsStore) InviteUserToGroup(ctx context.Context, groupID i
 	expiry := time.Now().Add(time.Hour * 23)
 
 	query = `
-		INSERT INTO group_invitations (user_id, group_id, expires_at)
-		VALUES ($1, $2, $3)
+		INSERT INTO group_invitations (user_id, group_id, expires_at, invited_by)
+		SELECT $1, $2, $3, $4
+		WHERE NOT EXISTS (
+			SELECT 1 FROM user_blocks
+			WHERE kind = 'block'
+				AND ((user_id = $1 AND blocked_user_id = $4) OR (user_id = $4 AND blocked_user_id = $1))
+		)
 	`
 
-	_, err = s.db.ExecContext(ctx, query, userID, groupID, expiry)
+	result, err := tx.ExecContext(ctx, query, userID, groupID, expiry, invitedBy)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrBlocked
+	}
+
+	return tx.Commit()
 }
 
-func (s *GroupInvitationsStore) AcceptInvitation(ctx context.Context, userID int, groupID int) error {
-	// Check if invitation is expired
+// AcceptInvitation adds the user to the group, or to the end of its waitlist
+// when the group is full, which it reports
+func (s *GroupInvitationsStore) AcceptInvitation(ctx context.Context, userID int, groupID int) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	seats, err := lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return false, err
+	}
+
+	// Delete invitation, checking it has not expired
 	query := `
-		SELECT EXISTS(SELECT 1 FROM group_invitations WHERE user_id = $1 AND group_id = $2 AND expires_at > NOW())
+		DELETE FROM group_invitations WHERE user_id = $1 AND group_id = $2 AND expires_at > NOW()
 	`
+	result, err := tx.ExecContext(ctx, query, userID, groupID)
+	if err != nil {
+		return false, err
+	}
 
-	row := s.db.QueryRowContext(ctx, query, userID, groupID)
-
-	var exists bool
-	err := row.Scan(&exists)
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, errors.New("invitation expired")
 	}
-	if !exists {
-		return errors.New("invitation expired")
+
+	if isFull(seats) {
+		// An invitation counts as approval, so the user goes straight onto the
+		// waitlist, keeping their place if they were already on it
+		query = `
+			INSERT INTO join_requests (user_id, group_id, status, waitlisted_at)
+			VALUES ($1, $2, 'waitlisted', NOW())
+			ON CONFLICT (user_id, group_id) DO UPDATE
+			SET status = 'waitlisted', waitlisted_at = COALESCE(join_requests.waitlisted_at, NOW())
+		`
+		_, err = tx.ExecContext(ctx, query, userID, groupID)
+		if err != nil {
+			return false, err
+		}
+
+		return true, tx.Commit()
 	}
 
-	// Delete invitation
+	// Insert user, settling any join request they had open
 	query = `
-		DELETE FROM group_invitations WHERE user_id = $1 AND group_id = $2
+		DELETE FROM join_requests WHERE user_id = $1 AND group_id = $2
 	`
-	_, err = s.db.ExecContext(ctx, query, userID, groupID)
+	_, err = tx.ExecContext(ctx, query, userID, groupID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	// Insert user
 	query = `
 		INSERT INTO membership (user_id, group_id, role)
 		VALUES ($1, $2, 'member')
 	`
 
-	_, err = s.db.ExecContext(ctx, query, userID, groupID)
+	_, err = tx.ExecContext(ctx, query, userID, groupID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	return false, tx.Commit()
 }
 
 func (s *GroupInvitationsStore) RejectInvitation(ctx context.Context, userID int, groupID int) error {
@@ -94,15 +165,25 @@ func (s *GroupInvitationsStore) RejectInvitation(ctx context.Context, userID int
 	return nil
 }
 
+// GetInvitations lists the user's open invitations, hiding those sent by users
+// they blocked or muted, or who blocked them
 func (s *GroupInvitationsStore) GetInvitations(ctx context.Context, userID int) ([]GroupInvitation, error) {
 	query := `
-		SELECT * FROM group_invitations WHERE user_id = $1 AND expires_at > NOW()
+		SELECT i.group_id, i.user_id, i.invited_at, i.expires_at
+		FROM group_invitations i
+		WHERE i.user_id = $1 AND i.expires_at > NOW()
+			AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE (b.user_id = $1 AND b.blocked_user_id = i.invited_by)
+					OR (b.user_id = i.invited_by AND b.blocked_user_id = $1 AND b.kind = 'block')
+			)
 	`
 
 	rows, err := s.db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var invitations []GroupInvitation
 	for rows.Next() {
@@ -115,5 +196,5 @@ func (s *GroupInvitationsStore) GetInvitations(ctx context.Context, userID int)
 		invitations = append(invitations, invitation)
 	}
 
-	return invitations, nil
+	return invitations, rows.Err()
 }
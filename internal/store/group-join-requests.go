@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"time"
 )
 
 type GroupJoinRequestsStore struct {
@@ -10,71 +11,141 @@ type GroupJoinRequestsStore struct {
 }
 
 type GroupJoinRequest struct {
-	ID      int    `json:"id"`
-	UserID  int    `json:"user_id"`
-	GroupID int    `json:"group_id"`
-	Status  string `json:"status"`
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	GroupID      int        `json:"group_id"`
+	Status       string     `json:"status"`
+	WaitlistedAt *time.Time `json:"waitlisted_at"`
 }
 
+// JoinRequest asks to join the group, returning ErrBanned when the user is
+// banned from it. The ban is checked under the group lock BanUser takes, so a
+// ban cannot land between the check and the request.
 func (s *GroupJoinRequestsStore) JoinRequest(ctx context.Context, groupID int, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+
 	query := `
 		INSERT INTO join_requests (user_id, group_id, status)
-		VALUES ($1, $2, 'pending')
+		SELECT $1, $2, 'pending'
+		WHERE NOT EXISTS (
+			SELECT 1 FROM group_bans b WHERE b.group_id = $2 AND b.user_id = $1 AND ` + activeBan + `
+		)
 	`
 
-	_, err := s.db.ExecContext(ctx, query, userID, groupID)
+	result, err := tx.ExecContext(ctx, query, userID, groupID)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrBanned
+	}
+
+	return tx.Commit()
 }
 
-func (s *GroupJoinRequestsStore) GetJoinRequests(ctx context.Context, groupID int) ([]GroupJoinRequest, error) {
+// GetJoinRequests lists a group's join requests as the viewer sees them, from
+// anyone but the users they have blocked or muted. Pending requests come first,
+// then the waitlist in order.
+func (s *GroupJoinRequestsStore) GetJoinRequests(ctx context.Context, groupID int, viewerID int) ([]GroupJoinRequest, error) {
 	query := `
-		SELECT * FROM join_requests WHERE group_id = $1
+		SELECT r.id, r.user_id, r.group_id, r.status, r.waitlisted_at
+		FROM join_requests r
+		WHERE r.group_id = $1 AND r.status IN ('pending', 'waitlisted')
+			AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b WHERE b.user_id = $2 AND b.blocked_user_id = r.user_id
+			)
+		ORDER BY r.waitlisted_at NULLS FIRST, r.id
 	`
 
-	rows, err := s.db.QueryContext(ctx, query, groupID)
+	rows, err := s.db.QueryContext(ctx, query, groupID, viewerID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	var joinRequests []GroupJoinRequest
 	for rows.Next() {
 		var joinRequest GroupJoinRequest
-		err := rows.Scan(&joinRequest.ID, &joinRequest.UserID, &joinRequest.GroupID, &joinRequest.Status)
+		var waitlistedAt sql.NullTime
+		err := rows.Scan(&joinRequest.ID, &joinRequest.UserID, &joinRequest.GroupID, &joinRequest.Status, &waitlistedAt)
 		if err != nil {
 			return nil, err
 		}
+		if waitlistedAt.Valid {
+			joinRequest.WaitlistedAt = &waitlistedAt.Time
+		}
 
 		joinRequests = append(joinRequests, joinRequest)
 	}
 
-	return joinRequests, nil
+	return joinRequests, rows.Err()
 }
 
-func (s *GroupJoinRequestsStore) ApproveJoinRequest(ctx context.Context, groupID int, userID int) error {
-	query := `
-		DELETE FROM join_requests WHERE group_id = $1 AND user_id = $2
-	`
+// ApproveJoinRequest lets the user into the group, or onto the end of its
+// waitlist when the group is full, which it reports. Without a pending request
+// it returns ErrNotFound.
+func (s *GroupJoinRequestsStore) ApproveJoinRequest(ctx context.Context, groupID int, userID int) (bool, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
 
-	_, err := s.db.ExecContext(ctx, query, groupID, userID)
+	seats, err := lockGroupSeats(ctx, tx, groupID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	query = `
-		INSERT INTO membership (user_id, group_id, status, role)
-		VALUES ($1, $2, 'member', 'member')
+	query := `
+		DELETE FROM join_requests WHERE group_id = $1 AND user_id = $2 AND status = 'pending'
 	`
+	if isFull(seats) {
+		query = `
+			UPDATE join_requests SET status = 'waitlisted', waitlisted_at = NOW()
+			WHERE group_id = $1 AND user_id = $2 AND status = 'pending'
+		`
+	}
 
-	_, err = s.db.ExecContext(ctx, query, userID, groupID)
+	result, err := tx.ExecContext(ctx, query, groupID, userID)
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	return nil
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if rowsAffected == 0 {
+		return false, ErrNotFound
+	}
+
+	if !isFull(seats) {
+		query = `
+			INSERT INTO membership (user_id, group_id, status, role)
+			VALUES ($1, $2, 'member', 'member')
+		`
+
+		_, err = tx.ExecContext(ctx, query, userID, groupID)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return isFull(seats), tx.Commit()
 }
 
 func (s *GroupJoinRequestsStore) RejectJoinRequest(ctx context.Context, groupID int, userID int) error {
@@ -3,16 +3,32 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"time"
 )
 
+// OwnershipTransferExpiry is how long the receiver has to accept a group
+const OwnershipTransferExpiry = time.Hour * 24 * 7
+
+// OwnershipTransfer is an owner's offer to hand their group to another member
+type OwnershipTransfer struct {
+	GroupID    int       `json:"group_id"`
+	GroupName  string    `json:"group_name"`
+	FromUserID int       `json:"from_user_id"`
+	ToUserID   int       `json:"to_user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
 type GroupMembershipManagementStore struct {
 	db *sql.DB
 }
 
-func (s *GroupMembershipManagementStore) MakeAdmin(ctx context.Context, groupID int, userID int) error {
+// AddOwner makes the user the owner of a group they just created
+func (s *GroupMembershipManagementStore) AddOwner(ctx context.Context, groupID int, userID int) error {
 	query := `
 		INSERT INTO membership (user_id, group_id, role)
-		VALUES ($1, $2, 'admin')
+		VALUES ($1, $2, 'owner')
 	`
 
 	_, err := s.db.ExecContext(ctx, query, userID, groupID)
@@ -24,15 +40,363 @@ func (s *GroupMembershipManagementStore) MakeAdmin(ctx context.Context, groupID
 
 }
 
+// LeaveGroup removes the user from the group, along with any ownership offer
+// made to them. The owner cannot leave, which returns ErrConflict, so a group is
+// never left without one. A user who is not a member returns ErrNotFound.
 func (s *GroupMembershipManagementStore) LeaveGroup(ctx context.Context, groupID int, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The group is locked before the membership, in the same order as
+	// DeleteAccount handing the group over
+	_, err = lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	role, err := lockRole(ctx, tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if role == RoleOwner {
+		return ErrConflict
+	}
+
+	err = deleteMembership(ctx, tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveMember takes a member out of the group on behalf of someone holding
+// byRole, who must outrank them or ErrConflict is returned. The owner is never
+// outranked so cannot be removed. A user who is not a member returns
+// ErrNotFound.
+func (s *GroupMembershipManagementStore) RemoveMember(ctx context.Context, groupID int, userID int, byRole string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The group is locked before the membership, in the same order as
+	// DeleteAccount handing the group over
+	_, err = lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	role, err := lockRole(ctx, tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if !Outranks(byRole, role) {
+		return ErrConflict
+	}
+
+	err = deleteMembership(ctx, tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// deleteMembership removes a user from a group along with any ownership offer
+// made to them or waitlist notice still owed to them, and hands their seat to
+// the waitlist
+func deleteMembership(ctx context.Context, tx *sql.Tx, groupID int, userID int) error {
 	query := `
 		DELETE FROM membership WHERE user_id = $1 AND group_id = $2
 	`
+	_, err := tx.ExecContext(ctx, query, userID, groupID)
+	if err != nil {
+		return err
+	}
 
-	_, err := s.db.ExecContext(ctx, query, userID, groupID)
+	query = `
+		DELETE FROM group_ownership_transfers WHERE group_id = $1 AND to_user_id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	// A promoted request is only kept until the user is told they got in, it
+	// would stop them asking to join again
+	query = `
+		DELETE FROM join_requests WHERE group_id = $1 AND user_id = $2 AND status = 'promoted'
+	`
+	_, err = tx.ExecContext(ctx, query, groupID, userID)
+	if err != nil {
+		return err
+	}
+
+	return fillFromWaitlist(ctx, tx, groupID)
+}
+
+// lockRole reads a member's role and locks their membership for the rest of
+// the transaction, returning ErrNotFound for non-members
+func lockRole(ctx context.Context, tx *sql.Tx, groupID int, userID int) (string, error) {
+	query := `
+		SELECT role FROM membership WHERE group_id = $1 AND user_id = $2 FOR UPDATE
+	`
+
+	var role string
+	err := tx.QueryRowContext(ctx, query, groupID, userID).Scan(&role)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return role, nil
+}
+
+// ChangeRole promotes a member to moderator or demotes a moderator back to
+// member. The owner's role only changes through an ownership transfer, trying
+// returns ErrConflict.
+func (s *GroupMembershipManagementStore) ChangeRole(ctx context.Context, groupID int, userID int, role string) error {
+	if role != RoleModerator && role != RoleMember {
+		return ErrInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The group is locked before the membership, like every other membership
+	// change
+	_, err = lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	current, err := lockRole(ctx, tx, groupID, userID)
+	if err != nil {
+		return err
+	}
+	if current == RoleOwner {
+		return ErrConflict
+	}
+
+	query := `
+		UPDATE membership SET role = $3 WHERE group_id = $1 AND user_id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, groupID, userID, role)
 	if err != nil {
 		return err
 	}
 
+	return tx.Commit()
+}
+
+const ownershipTransferColumns = `t.group_id, g.name, t.from_user_id, t.to_user_id, t.created_at, t.expires_at`
+
+func scanOwnershipTransfer(row interface{ Scan(dest ...any) error }, transfer *OwnershipTransfer) error {
+	return row.Scan(&transfer.GroupID, &transfer.GroupName, &transfer.FromUserID, &transfer.ToUserID, &transfer.CreatedAt, &transfer.ExpiresAt)
+}
+
+// OfferOwnership offers the group to another member, replacing any earlier
+// offer. A receiver who is not a member returns ErrNotFound.
+func (s *GroupMembershipManagementStore) OfferOwnership(ctx context.Context, groupID int, fromUserID int, toUserID int) (OwnershipTransfer, error) {
+	if fromUserID == toUserID {
+		return OwnershipTransfer{}, ErrInvalid
+	}
+
+	query := `
+		WITH offer AS (
+			INSERT INTO group_ownership_transfers (group_id, from_user_id, to_user_id, expires_at)
+			SELECT $1, $2, $3, $4
+			WHERE EXISTS (SELECT 1 FROM membership WHERE group_id = $1 AND user_id = $3)
+			ON CONFLICT (group_id) DO UPDATE
+			SET from_user_id = EXCLUDED.from_user_id,
+				to_user_id = EXCLUDED.to_user_id,
+				created_at = NOW(),
+				expires_at = EXCLUDED.expires_at
+			RETURNING *
+		)
+		SELECT ` + ownershipTransferColumns + `
+		FROM offer t
+		JOIN groups g ON g.id = t.group_id
+	`
+
+	var transfer OwnershipTransfer
+	err := scanOwnershipTransfer(s.db.QueryRowContext(ctx, query, groupID, fromUserID, toUserID, time.Now().Add(OwnershipTransferExpiry)), &transfer)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return OwnershipTransfer{}, ErrNotFound
+		default:
+			return OwnershipTransfer{}, err
+		}
+	}
+
+	return transfer, nil
+}
+
+// GetOwnershipTransfer returns the group's pending ownership offer, or
+// ErrNotFound when there is none
+func (s *GroupMembershipManagementStore) GetOwnershipTransfer(ctx context.Context, groupID int) (OwnershipTransfer, error) {
+	query := `
+		SELECT ` + ownershipTransferColumns + `
+		FROM group_ownership_transfers t
+		JOIN groups g ON g.id = t.group_id
+		WHERE t.group_id = $1 AND t.expires_at > NOW()
+	`
+
+	var transfer OwnershipTransfer
+	err := scanOwnershipTransfer(s.db.QueryRowContext(ctx, query, groupID), &transfer)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return OwnershipTransfer{}, ErrNotFound
+		default:
+			return OwnershipTransfer{}, err
+		}
+	}
+
+	return transfer, nil
+}
+
+// GetOwnershipOffers lists the groups the user has been offered and not yet
+// answered
+func (s *GroupMembershipManagementStore) GetOwnershipOffers(ctx context.Context, userID int) ([]OwnershipTransfer, error) {
+	query := `
+		SELECT ` + ownershipTransferColumns + `
+		FROM group_ownership_transfers t
+		JOIN groups g ON g.id = t.group_id
+		WHERE t.to_user_id = $1 AND t.expires_at > NOW()
+		ORDER BY t.created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	offers := []OwnershipTransfer{}
+	for rows.Next() {
+		var transfer OwnershipTransfer
+		err := scanOwnershipTransfer(rows, &transfer)
+		if err != nil {
+			return nil, err
+		}
+
+		offers = append(offers, transfer)
+	}
+
+	return offers, rows.Err()
+}
+
+// CancelOwnershipTransfer withdraws the group's pending offer, returning
+// ErrNotFound when there was none
+func (s *GroupMembershipManagementStore) CancelOwnershipTransfer(ctx context.Context, groupID int) error {
+	query := `
+		DELETE FROM group_ownership_transfers WHERE group_id = $1
+	`
+
+	return s.deleteOwnershipTransfer(ctx, query, groupID)
+}
+
+// DeclineOwnershipTransfer turns down an offer made to the user, returning
+// ErrNotFound when there was none
+func (s *GroupMembershipManagementStore) DeclineOwnershipTransfer(ctx context.Context, groupID int, userID int) error {
+	query := `
+		DELETE FROM group_ownership_transfers WHERE group_id = $1 AND to_user_id = $2
+	`
+
+	return s.deleteOwnershipTransfer(ctx, query, groupID, userID)
+}
+
+func (s *GroupMembershipManagementStore) deleteOwnershipTransfer(ctx context.Context, query string, args ...any) error {
+	result, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
 	return nil
 }
+
+// AcceptOwnershipTransfer makes the user the owner of a group they were
+// offered, and the previous owner a moderator. Without a pending offer it
+// returns ErrNotFound, and ErrConflict when the offer no longer holds because
+// the owner changed in the meantime.
+func (s *GroupMembershipManagementStore) AcceptOwnershipTransfer(ctx context.Context, groupID int, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The group is locked before the offer and the memberships, in the same
+	// order as a member leaving deletes them
+	_, err = lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		DELETE FROM group_ownership_transfers
+		WHERE group_id = $1 AND to_user_id = $2 AND expires_at > NOW()
+		RETURNING from_user_id
+	`
+
+	var fromUserID int
+	err = tx.QueryRowContext(ctx, query, groupID, userID).Scan(&fromUserID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	// Locked in user id order so two transfers in the same group cannot deadlock
+	first, second := min(fromUserID, userID), max(fromUserID, userID)
+	roles := map[int]string{}
+	for _, id := range []int{first, second} {
+		roles[id], err = lockRole(ctx, tx, groupID, id)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	if roles[fromUserID] != RoleOwner || roles[userID] == "" {
+		return ErrConflict
+	}
+
+	// The old owner steps down first, a group only has one owner at a time
+	query = `
+		UPDATE membership SET role = $3 WHERE group_id = $1 AND user_id = $2
+	`
+	_, err = tx.ExecContext(ctx, query, groupID, fromUserID, RoleModerator)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, query, groupID, userID, RoleOwner)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
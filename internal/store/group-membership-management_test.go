@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+)
+
+func TestAcceptOwnershipTransferWhileReceiverLeaves(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	for range 10 {
+		groupID, ownerID := createTestGroup(t, s, 0)
+		receiverID := addTestMember(t, s, groupID)
+
+		_, err := s.GroupMembershipManagement.OfferOwnership(ctx, groupID, ownerID, receiverID)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			// The offer is gone if the receiver left first
+			err := s.GroupMembershipManagement.AcceptOwnershipTransfer(ctx, groupID, receiverID)
+			if err != nil && !errors.Is(err, ErrNotFound) {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			// An owner cannot leave if the offer was accepted first
+			err := s.GroupMembershipManagement.LeaveGroup(ctx, groupID, receiverID)
+			if err != nil && !errors.Is(err, ErrConflict) {
+				t.Error(err)
+			}
+		}()
+		wg.Wait()
+
+		if t.Failed() {
+			return
+		}
+	}
+}
@@ -3,29 +3,45 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"time"
 )
 
 type GroupMembershipStore struct {
 	db *sql.DB
 }
 
-func (s *GroupMembershipStore) GetGroupMembers(ctx context.Context, groupID int) ([]User, error) {
+// GroupMember is a user along with their role in the group
+type GroupMember struct {
+	User
+	Role     string    `json:"role"`
+	JoinedAt time.Time `json:"joined_at"`
+}
+
+// GetGroupMembers lists the members of a group, leaving out anyone the viewer
+// has blocked
+func (s *GroupMembershipStore) GetGroupMembers(ctx context.Context, groupID int, viewerID int) ([]GroupMember, error) {
 	query := `
-		SELECT u.id, u.first_name, u.last_name, u.email
+		SELECT u.id, u.first_name, u.last_name, u.email, m.role, m.joined_at
 		FROM users u
 		JOIN membership m ON u.id = m.user_id
-		WHERE m.group_id = $1 AND (m.role = 'member' OR m.role = 'admin')
+		WHERE m.group_id = $1
+			AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE b.user_id = $2 AND b.blocked_user_id = u.id AND b.kind = 'block'
+			)
 	`
 
-	rows, err := s.db.QueryContext(ctx, query, groupID)
+	rows, err := s.db.QueryContext(ctx, query, groupID, viewerID)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
-	var members []User
+	var members []GroupMember
 	for rows.Next() {
-		var member User
-		err := rows.Scan(&member.ID, &member.FirstName, &member.LastName, &member.Email)
+		var member GroupMember
+		err := rows.Scan(&member.ID, &member.FirstName, &member.LastName, &member.Email, &member.Role, &member.JoinedAt)
 		if err != nil {
 			return nil, err
 		}
@@ -33,7 +49,7 @@ func (s *GroupMembershipStore) GetGroupMembers(ctx context.Context, groupID int)
 		members = append(members, member)
 	}
 
-	return members, nil
+	return members, rows.Err()
 }
 
 func (s *GroupMembershipStore) GetMemberCount(ctx context.Context, groupID int) (int, error) {
@@ -52,20 +68,25 @@ func (s *GroupMembershipStore) GetMemberCount(ctx context.Context, groupID int)
 	return count, nil
 }
 
-func (s *GroupMembershipStore) IsAdmin(ctx context.Context, groupID int, userID int) (bool, error) {
+// GetRole returns the user's role in the group, or an empty role when they are
+// not a member
+func (s *GroupMembershipStore) GetRole(ctx context.Context, groupID int, userID int) (string, error) {
 	query := `
-		SELECT EXISTS(SELECT 1 FROM membership WHERE group_id = $1 AND user_id = $2 AND role = 'admin')
+		SELECT role FROM membership WHERE group_id = $1 AND user_id = $2
 	`
 
-	row := s.db.QueryRowContext(ctx, query, groupID, userID)
-
-	var exists bool
-	err := row.Scan(&exists)
+	var role string
+	err := s.db.QueryRowContext(ctx, query, groupID, userID).Scan(&role)
 	if err != nil {
-		return false, err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", nil
+		default:
+			return "", err
+		}
 	}
 
-	return exists, nil
+	return role, nil
 }
 
 func (s *GroupMembershipStore) IsMember(ctx context.Context, groupID int, userID int) (bool, error) {
@@ -3,6 +3,7 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 )
@@ -22,6 +23,11 @@ type Group struct {
 	CreatedAt      time.Time `json:"created_at"`
 	UpdatedAt      time.Time `json:"updated_at"`
 	Visibility     string    `json:"visibility"`
+	// CoverKey is the blob storage key of the cover image, the URLs are
+	// filled in by the API
+	CoverKey          string `json:"-"`
+	CoverURL          string `json:"cover_url,omitempty"`
+	CoverThumbnailURL string `json:"cover_thumbnail_url,omitempty"`
 }
 
 func (s *GroupRepository) CreateGroup(ctx context.Context, group *Group) (int, error) {
@@ -55,7 +61,7 @@ func (s *GroupRepository) CreateGroup(ctx context.Context, group *Group) (int, e
 
 func (s *GroupRepository) GetGroupByID(ctx context.Context, id int) (Group, error) {
 	query := `
-		SELECT id, name, description, has_member_limit, member_limit, subject, location, visibility
+		SELECT id, name, description, has_member_limit, member_limit, subject, location, visibility, created_at, updated_at, COALESCE(cover_key, '')
 		FROM groups
 		WHERE id = $1
 	`
@@ -64,7 +70,7 @@ func (s *GroupRepository) GetGroupByID(ctx context.Context, id int) (Group, erro
 
 	var group Group
 	var memberLimit sql.NullInt64
-	err := row.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility)
+	err := row.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility, &group.CreatedAt, &group.UpdatedAt, &group.CoverKey)
 	if err != nil {
 		return Group{}, err
 	}
@@ -80,10 +86,10 @@ func (s *GroupRepository) GetGroupByID(ctx context.Context, id int) (Group, erro
 
 func (s *GroupRepository) GetUserGroups(ctx context.Context, userID int) ([]Group, error) {
 	query := `
-		SELECT g.id, g.name, g.description, g.has_member_limit, g.member_limit, g.subject, g.location, g.visibility, g.created_at
+		SELECT g.id, g.name, g.description, g.has_member_limit, g.member_limit, g.subject, g.location, g.visibility, g.created_at, COALESCE(g.cover_key, '')
 		FROM groups g
 		JOIN membership m ON g.id = m.group_id
-		WHERE m.user_id = $1 AND m.role = 'admin'
+		WHERE m.user_id = $1 AND m.role IN ('owner', 'moderator')
 	`
 
 	rows, err := s.db.QueryContext(ctx, query, userID)
@@ -95,7 +101,7 @@ func (s *GroupRepository) GetUserGroups(ctx context.Context, userID int) ([]Grou
 	for rows.Next() {
 		var group Group
 		var memberLimit sql.NullInt64
-		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility, &group.CreatedAt)
+		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility, &group.CreatedAt, &group.CoverKey)
 		if err != nil {
 			return nil, err
 		}
@@ -114,7 +120,11 @@ func (s *GroupRepository) GetUserGroups(ctx context.Context, userID int) ([]Grou
 
 func (s *GroupRepository) SearchGroup(ctx context.Context, searchQuery string) ([]Group, error) {
 	query := `
-       SELECT * FROM groups WHERE name ILIKE $1 || '%' AND visibility = 'public' ORDER BY name ASC`
+		SELECT id, name, has_member_limit, member_limit, description, subject, location, created_at, updated_at, visibility, COALESCE(cover_key, '')
+		FROM groups
+		WHERE name ILIKE $1 || '%' AND visibility = 'public'
+		ORDER BY name ASC
+	`
 
 	rows, err := s.db.QueryContext(ctx, query, searchQuery)
 	if err != nil {
@@ -125,7 +135,7 @@ func (s *GroupRepository) SearchGroup(ctx context.Context, searchQuery string) (
 	for rows.Next() {
 		var group Group
 		var memberLimit sql.NullInt64
-		err := rows.Scan(&group.ID, &group.Name, &group.HasMemberLimit, &memberLimit, &group.Description, &group.Subject, &group.Location, &group.CreatedAt, &group.UpdatedAt, &group.Visibility)
+		err := rows.Scan(&group.ID, &group.Name, &group.HasMemberLimit, &memberLimit, &group.Description, &group.Subject, &group.Location, &group.CreatedAt, &group.UpdatedAt, &group.Visibility, &group.CoverKey)
 		if err != nil {
 			return nil, err
 		}
@@ -144,10 +154,10 @@ func (s *GroupRepository) SearchGroup(ctx context.Context, searchQuery string) (
 
 func (s *GroupRepository) GetJoinedGroups(ctx context.Context, userID int) ([]Group, error) {
 	query := `
-		SELECT g.id, g.name, g.description, g.has_member_limit, g.member_limit, g.subject, g.location, g.visibility
+		SELECT g.id, g.name, g.description, g.has_member_limit, g.member_limit, g.subject, g.location, g.visibility, COALESCE(g.cover_key, '')
 		FROM groups g
 		JOIN membership m ON g.id = m.group_id
-		WHERE m.user_id = $1 AND m.role != 'admin'
+		WHERE m.user_id = $1 AND m.role = 'member'
 	`
 
 	rows, err := s.db.QueryContext(ctx, query, userID)
@@ -159,7 +169,7 @@ func (s *GroupRepository) GetJoinedGroups(ctx context.Context, userID int) ([]Gr
 	for rows.Next() {
 		var group Group
 		var memberLimit sql.NullInt64
-		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility)
+		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility, &group.CoverKey)
 		if err != nil {
 			return nil, err
 		}
@@ -178,7 +188,7 @@ func (s *GroupRepository) GetJoinedGroups(ctx context.Context, userID int) ([]Gr
 
 func (s *GroupRepository) GetAllGroups(ctx context.Context) ([]Group, error) {
 	query := `
-		SELECT g.id, g.name, g.description, g.has_member_limit, g.member_limit, g.subject, g.location, g.visibility
+		SELECT g.id, g.name, g.description, g.has_member_limit, g.member_limit, g.subject, g.location, g.visibility, COALESCE(g.cover_key, '')
 		FROM groups g
 		WHERE g.visibility = 'public'
 	`
@@ -192,7 +202,7 @@ func (s *GroupRepository) GetAllGroups(ctx context.Context) ([]Group, error) {
 	for rows.Next() {
 		var group Group
 		var memberLimit sql.NullInt64
-		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility)
+		err := rows.Scan(&group.ID, &group.Name, &group.Description, &group.HasMemberLimit, &memberLimit, &group.Subject, &group.Location, &group.Visibility, &group.CoverKey)
 		if err != nil {
 			fmt.Println(err)
 			return nil, err
@@ -210,10 +220,187 @@ func (s *GroupRepository) GetAllGroups(ctx context.Context) ([]Group, error) {
 	return groups, nil
 }
 
-func (s *GroupRepository) DeleteGroup(ctx context.Context, groupID int) error {
+// GroupPatch holds the editable fields of a group that an update sets, nil
+// fields are left as they are
+type GroupPatch struct {
+	Name           *string `json:"name"`
+	Description    *string `json:"description"`
+	HasMemberLimit *bool   `json:"has_member_limit"`
+	MemberLimit    *int    `json:"member_limit"`
+	Subject        *string `json:"subject"`
+	Location       *string `json:"location"`
+	Visibility     *string `json:"visibility"`
+}
+
+func (p GroupPatch) apply(group *Group) {
+	if p.Name != nil {
+		group.Name = *p.Name
+	}
+	if p.Description != nil {
+		group.Description = *p.Description
+	}
+	if p.Subject != nil {
+		group.Subject = *p.Subject
+	}
+	if p.Location != nil {
+		group.Location = *p.Location
+	}
+	if p.Visibility != nil {
+		group.Visibility = *p.Visibility
+	}
+	if p.HasMemberLimit != nil {
+		group.HasMemberLimit = *p.HasMemberLimit
+	}
+	if p.MemberLimit != nil {
+		group.MemberLimit = *p.MemberLimit
+	}
+	if !group.HasMemberLimit {
+		group.MemberLimit = 0
+	}
+}
+
+// UpdateGroup applies the patch to the group as it is now and saves it,
+// recording every field that changed against the user who changed it. The
+// group stays locked from the read to the write, so concurrent updates of
+// different fields do not undo each other.
+//
+// The patched group must pass validate, otherwise the error is returned
+// wrapped in ErrInvalid. Nothing is written when no field changed. A member
+// limit below the current number of members returns ErrConflict, and a raised
+// one lets people in from the waitlist.
+func (s *GroupRepository) UpdateGroup(ctx context.Context, groupID int, patch GroupPatch, changedBy int, validate func(*Group) error) (Group, []GroupChange, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Group{}, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id, name, description, has_member_limit, member_limit, subject, location, visibility, created_at, updated_at, COALESCE(cover_key, '')
+		FROM groups
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	var old Group
+	var memberLimit sql.NullInt64
+	err = tx.QueryRowContext(ctx, query, groupID).Scan(&old.ID, &old.Name, &old.Description, &old.HasMemberLimit, &memberLimit, &old.Subject, &old.Location, &old.Visibility, &old.CreatedAt, &old.UpdatedAt, &old.CoverKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return Group{}, nil, ErrNotFound
+		default:
+			return Group{}, nil, err
+		}
+	}
+	if memberLimit.Valid {
+		old.MemberLimit = int(memberLimit.Int64)
+	}
+
+	group := old
+	patch.apply(&group)
+
+	err = validate(&group)
+	if err != nil {
+		return Group{}, nil, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+
+	changes := diffGroups(old, group)
+	if len(changes) == 0 {
+		return group, changes, nil
+	}
+
+	if group.HasMemberLimit {
+		var memberCount int
+		err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM membership WHERE group_id = $1`, group.ID).Scan(&memberCount)
+		if err != nil {
+			return Group{}, nil, err
+		}
+		if group.MemberLimit < memberCount {
+			return Group{}, nil, ErrConflict
+		}
+	}
+
+	newLimit := sql.NullInt64{Int64: int64(group.MemberLimit), Valid: group.HasMemberLimit}
+
+	query = `
+		UPDATE groups
+		SET name = $1, description = $2, has_member_limit = $3, member_limit = $4, subject = $5, location = $6,
+			visibility = $7, updated_at = NOW()
+		WHERE id = $8
+		RETURNING updated_at
+	`
+	err = tx.QueryRowContext(ctx, query, group.Name, group.Description, group.HasMemberLimit, newLimit, group.Subject, group.Location, group.Visibility, group.ID).Scan(&group.UpdatedAt)
+	if err != nil {
+		return Group{}, nil, err
+	}
+
+	query = `
+		INSERT INTO group_changes (group_id, user_id, field, old_value, new_value, changed_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	for i := range changes {
+		changes[i].GroupID = group.ID
+		changes[i].UserID = &changedBy
+		changes[i].ChangedAt = group.UpdatedAt
+
+		err = tx.QueryRowContext(ctx, query, group.ID, changedBy, changes[i].Field, changes[i].OldValue, changes[i].NewValue, group.UpdatedAt).Scan(&changes[i].ID)
+		if err != nil {
+			return Group{}, nil, err
+		}
+	}
+
+	err = fillFromWaitlist(ctx, tx, group.ID)
+	if err != nil {
+		return Group{}, nil, err
+	}
+
+	return group, changes, tx.Commit()
+}
+
+// DeleteGroup deletes the group and returns its cover image key, if any, so
+// the blobs can be deleted too
+func (s *GroupRepository) DeleteGroup(ctx context.Context, groupID int) (string, error) {
 	query := `
 		DELETE FROM groups WHERE id = $1
+		RETURNING COALESCE(cover_key, '')
 	`
-	_, err := s.db.ExecContext(ctx, query, groupID)
-	return err
+
+	var coverKey string
+	err := s.db.QueryRowContext(ctx, query, groupID).Scan(&coverKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return coverKey, nil
+}
+
+// SetCoverImage stores the group's new cover image key, or clears it when key
+// is empty, and returns the key it replaced so the old blobs can be deleted
+func (s *GroupRepository) SetCoverImage(ctx context.Context, groupID int, key string) (string, error) {
+	query := `
+		UPDATE groups g SET cover_key = NULLIF($1, ''), updated_at = NOW()
+		FROM (SELECT id, cover_key FROM groups WHERE id = $2 FOR UPDATE) old
+		WHERE g.id = old.id
+		RETURNING COALESCE(old.cover_key, '')
+	`
+
+	var previousKey string
+	err := s.db.QueryRowContext(ctx, query, key, groupID).Scan(&previousKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNotFound
+		default:
+			return "", err
+		}
+	}
+
+	return previousKey, nil
 }
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func noValidation(*Group) error { return nil }
+
+func TestConcurrentGroupUpdatesKeepEachOthersFields(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	groupID, ownerID := createTestGroup(t, s, 0)
+
+	name := "Renamed"
+	location := "Library"
+	patches := []GroupPatch{{Name: &name}, {Location: &location}}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(patches))
+	for _, patch := range patches {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.GroupRepository.UpdateGroup(ctx, groupID, patch, ownerID, noValidation)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	group, err := s.GroupRepository.GetGroupByID(ctx, groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group.Name != name || group.Location != location {
+		t.Fatalf("got name %q and location %q, want %q and %q", group.Name, group.Location, name, location)
+	}
+}
@@ -0,0 +1,79 @@
+package store
+
+import "slices"
+
+// Group membership roles. Every group has exactly one owner.
+const (
+	RoleOwner     = "owner"
+	RoleModerator = "moderator"
+	RoleMember    = "member"
+)
+
+// Permission is something a member may be allowed to do in a group
+type Permission string
+
+const (
+	PermissionApproveRequests Permission = "approve_requests"
+	PermissionInvite          Permission = "invite"
+	PermissionCreateSessions  Permission = "create_sessions"
+	PermissionEditGroup       Permission = "edit_group"
+	PermissionRemoveMembers   Permission = "remove_members"
+	PermissionBanMembers      Permission = "ban_members"
+	PermissionDeleteGroup     Permission = "delete_group"
+	PermissionManageRoles     Permission = "manage_roles"
+	PermissionTransferGroup   Permission = "transfer_group"
+)
+
+// rolePermissions is the permission matrix, the single place that decides who
+// may do what in a group
+var rolePermissions = map[string][]Permission{
+	RoleOwner: {
+		PermissionApproveRequests,
+		PermissionInvite,
+		PermissionCreateSessions,
+		PermissionEditGroup,
+		PermissionRemoveMembers,
+		PermissionBanMembers,
+		PermissionDeleteGroup,
+		PermissionManageRoles,
+		PermissionTransferGroup,
+	},
+	RoleModerator: {
+		PermissionApproveRequests,
+		PermissionInvite,
+		PermissionCreateSessions,
+		PermissionRemoveMembers,
+		PermissionBanMembers,
+	},
+	RoleMember: {},
+}
+
+// RoleCan reports whether the role grants the permission. Unknown roles,
+// including the empty role of non-members, grant nothing.
+func RoleCan(role string, permission Permission) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// RolePermissions lists what the role grants
+func RolePermissions(role string) []Permission {
+	return append([]Permission{}, rolePermissions[role]...)
+}
+
+// roleRanks orders the roles from the bottom up. Non-members rank below
+// everyone.
+var roleRanks = map[string]int{
+	RoleMember:    1,
+	RoleModerator: 2,
+	RoleOwner:     3,
+}
+
+// Outranks reports whether the role sits above the other one. Members can only
+// be removed or banned by someone who outranks them.
+func Outranks(role string, other string) bool {
+	return roleRanks[role] > roleRanks[other]
+}
+
+// IsManager reports whether the role is one that helps run the group
+func IsManager(role string) bool {
+	return role == RoleOwner || role == RoleModerator
+}
@@ -0,0 +1,66 @@
+package store
+
+import "testing"
+
+func TestRoleCan(t *testing.T) {
+	permissions := []Permission{
+		PermissionApproveRequests,
+		PermissionInvite,
+		PermissionCreateSessions,
+		PermissionEditGroup,
+		PermissionRemoveMembers,
+		PermissionBanMembers,
+		PermissionDeleteGroup,
+		PermissionManageRoles,
+		PermissionTransferGroup,
+	}
+
+	granted := map[string][]Permission{
+		RoleOwner: permissions,
+		RoleModerator: {
+			PermissionApproveRequests,
+			PermissionInvite,
+			PermissionCreateSessions,
+			PermissionRemoveMembers,
+			PermissionBanMembers,
+		},
+		RoleMember: nil,
+		"":         nil,
+		"admin":    nil,
+	}
+
+	for role, grants := range granted {
+		for _, permission := range permissions {
+			want := false
+			for _, grant := range grants {
+				want = want || grant == permission
+			}
+
+			if got := RoleCan(role, permission); got != want {
+				t.Errorf("RoleCan(%q, %q) = %t, want %t", role, permission, got, want)
+			}
+		}
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	// From the bottom up, unknown roles rank with non-members
+	ranks := [][]string{
+		{"", "admin"},
+		{RoleMember},
+		{RoleModerator},
+		{RoleOwner},
+	}
+
+	for i, roles := range ranks {
+		for j, others := range ranks {
+			for _, role := range roles {
+				for _, other := range others {
+					if got, want := Outranks(role, other), i > j; got != want {
+						t.Errorf("Outranks(%q, %q) = %t, want %t", role, other, got, want)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Join request statuses. A pending request waits for the group's owner or a
+// moderator. An approved request waits on the waitlist while the group is
+// full, and is kept as promoted once it made it in until the user has been
+// told.
+const (
+	JoinRequestPending    = "pending"
+	JoinRequestWaitlisted = "waitlisted"
+	JoinRequestPromoted   = "promoted"
+)
+
+// WaitlistPromotion is a user who was let into a group from its waitlist and
+// has not been told yet
+type WaitlistPromotion struct {
+	ID        int
+	GroupID   int
+	GroupName string
+	UserID    int
+	Email     string
+	FirstName string
+}
+
+// lockGroupSeats locks the group's row, which serialises every change to its
+// membership, and returns how many more members it can take. The count is not
+// valid when the group has no member limit. An unknown group returns
+// ErrNotFound.
+func lockGroupSeats(ctx context.Context, tx *sql.Tx, groupID int) (sql.NullInt64, error) {
+	query := `
+		SELECT CASE WHEN has_member_limit
+			THEN GREATEST(member_limit - (SELECT COUNT(*) FROM membership WHERE group_id = $1), 0)
+		END
+		FROM groups
+		WHERE id = $1
+		FOR UPDATE
+	`
+
+	var seats sql.NullInt64
+	err := tx.QueryRowContext(ctx, query, groupID).Scan(&seats)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return sql.NullInt64{}, ErrNotFound
+		default:
+			return sql.NullInt64{}, err
+		}
+	}
+
+	return seats, nil
+}
+
+// isFull reports whether the group has no seat left
+func isFull(seats sql.NullInt64) bool {
+	return seats.Valid && seats.Int64 == 0
+}
+
+// fillFromWaitlist gives the group's free seats to the first users on its
+// waitlist. It runs whenever a seat may have freed up.
+func fillFromWaitlist(ctx context.Context, tx *sql.Tx, groupID int) error {
+	seats, err := lockGroupSeats(ctx, tx, groupID)
+	if err != nil {
+		return err
+	}
+	if isFull(seats) {
+		return nil
+	}
+
+	// A NULL limit takes everyone, the group has no member limit
+	query := `
+		WITH next AS (
+			SELECT id FROM join_requests
+			WHERE group_id = $1 AND status = 'waitlisted'
+			ORDER BY waitlisted_at, id
+			LIMIT $2
+		), promoted AS (
+			UPDATE join_requests r SET status = 'promoted'
+			FROM next
+			WHERE r.id = next.id
+			RETURNING r.user_id
+		)
+		INSERT INTO membership (user_id, group_id, status, role)
+		SELECT user_id, $1, 'member', 'member' FROM promoted
+	`
+
+	_, err = tx.ExecContext(ctx, query, groupID, seats)
+	return err
+}
+
+// NotifyWaitlistPromotion hands the oldest promotion after afterID to notify
+// and forgets it once notify succeeds. The row stays locked in the meantime, so
+// other replicas running the job skip it instead of telling the user twice. A
+// failed notification is kept to be retried on a later run.
+//
+// The claimed promotion's ID is returned even when notify fails, so the caller
+// can move past it. ErrNotFound means there are none left.
+func (s *GroupJoinRequestsStore) NotifyWaitlistPromotion(ctx context.Context, afterID int, notify func(WaitlistPromotion) error) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Only the join request is locked, the group must stay free to change
+	// while the email is sent
+	query := `
+		SELECT r.id, r.group_id, g.name, r.user_id, u.email, u.first_name
+		FROM join_requests r
+		JOIN groups g ON g.id = r.group_id
+		JOIN users u ON u.id = r.user_id
+		WHERE r.status = 'promoted' AND r.id > $1
+		ORDER BY r.id
+		LIMIT 1
+		FOR UPDATE OF r SKIP LOCKED
+	`
+
+	var promotion WaitlistPromotion
+	err = tx.QueryRowContext(ctx, query, afterID).Scan(&promotion.ID, &promotion.GroupID, &promotion.GroupName, &promotion.UserID, &promotion.Email, &promotion.FirstName)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	err = notify(promotion)
+	if err != nil {
+		return promotion.ID, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM join_requests WHERE id = $1`, promotion.ID)
+	if err != nil {
+		return promotion.ID, err
+	}
+
+	return promotion.ID, tx.Commit()
+}
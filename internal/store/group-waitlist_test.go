@@ -0,0 +1,122 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentJoinsDoNotExceedMemberLimit(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	const limit = 3
+	const joiners = 8
+	groupID, ownerID := createTestGroup(t, s, limit)
+
+	var requesters, invitees []int
+	for range joiners {
+		userID := createTestUser(t, s)
+		err := s.GroupJoinRequests.JoinRequest(ctx, groupID, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requesters = append(requesters, userID)
+
+		userID = createTestUser(t, s)
+		err = s.GroupInvitations.InviteUserToGroup(ctx, groupID, userID, ownerID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		invitees = append(invitees, userID)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*joiners)
+	for i := range joiners {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, err := s.GroupJoinRequests.ApproveJoinRequest(ctx, groupID, requesters[i])
+			errs <- err
+		}()
+		go func() {
+			defer wg.Done()
+			_, err := s.GroupInvitations.AcceptInvitation(ctx, invitees[i], groupID)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := s.GroupMembership.GetMemberCount(ctx, groupID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != limit {
+		t.Fatalf("group has %d members, want its limit of %d", count, limit)
+	}
+
+	requests, err := s.GroupJoinRequests.GetJoinRequests(ctx, groupID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Everyone but the owner was approved, the ones without a seat wait
+	if want := 2*joiners - (limit - 1); len(requests) != want {
+		t.Fatalf("%d users are waitlisted, want %d", len(requests), want)
+	}
+	for _, request := range requests {
+		if request.Status != JoinRequestWaitlisted {
+			t.Errorf("user %d has status %q, want %q", request.UserID, request.Status, JoinRequestWaitlisted)
+		}
+	}
+}
+
+func TestPromotedMemberCanRejoinAfterLeaving(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	groupID, _ := createTestGroup(t, s, 2)
+
+	member := createTestUser(t, s)
+	waiting := createTestUser(t, s)
+	for _, userID := range []int{member, waiting} {
+		err := s.GroupJoinRequests.JoinRequest(ctx, groupID, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = s.GroupJoinRequests.ApproveJoinRequest(ctx, groupID, userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The seat goes to the waitlist, and the promotion waits to be announced
+	err := s.GroupMembershipManagement.LeaveGroup(ctx, groupID, member)
+	if err != nil {
+		t.Fatal(err)
+	}
+	isMember, err := s.GroupMembership.IsMember(ctx, groupID, waiting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isMember {
+		t.Fatal("waitlisted user was not promoted")
+	}
+
+	err = s.GroupMembershipManagement.LeaveGroup(ctx, groupID, waiting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.GroupJoinRequests.JoinRequest(ctx, groupID, waiting)
+	if err != nil {
+		t.Fatalf("asking to rejoin: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type IdentityStore struct {
+	db *sql.DB
+}
+
+// CreateLoginState remembers the PKCE verifier and nonce of an authorization
+// request until its callback arrives. Only the hash of the state is stored.
+func (s *IdentityStore) CreateLoginState(ctx context.Context, state string, provider string, verifier string, nonce string, expiresAt time.Time) error {
+	query := `
+		INSERT INTO oidc_login_states (state_hash, provider, code_verifier, nonce, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, hashToken(state), provider, verifier, nonce, expiresAt)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConsumeLoginState deletes and returns the verifier and nonce for a state, so
+// each authorization response can only be redeemed once.
+func (s *IdentityStore) ConsumeLoginState(ctx context.Context, state string, provider string) (string, string, error) {
+	// Clear out abandoned logins while we are here
+	query := `
+		DELETE FROM oidc_login_states WHERE expires_at < NOW()
+	`
+	_, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return "", "", err
+	}
+
+	query = `
+		DELETE FROM oidc_login_states
+		WHERE state_hash = $1 AND provider = $2
+		RETURNING code_verifier, nonce
+	`
+
+	var verifier, nonce string
+	err = s.db.QueryRowContext(ctx, query, hashToken(state), provider).Scan(&verifier, &nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", "", ErrInvalidToken
+		default:
+			return "", "", err
+		}
+	}
+
+	return verifier, nonce, nil
+}
+
+func (s *IdentityStore) GetUserIDByIdentity(ctx context.Context, provider string, subject string) (int, error) {
+	query := `
+		SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2
+	`
+
+	var userID int
+	err := s.db.QueryRowContext(ctx, query, provider, subject).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	return userID, nil
+}
+
+// LinkIdentity attaches a provider account to an existing user. The provider
+// has verified the email, so the user's email is marked verified as well.
+//
+// A local account whose email was never verified may have been registered by
+// someone else ahead of the address's owner. Before the owner gets it, every
+// other way in is taken away: the password, sessions, API keys, passkeys, MFA
+// and pending sign-in links.
+func (s *IdentityStore) LinkIdentity(ctx context.Context, userID int, provider string, subject string, email string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE
+	`
+
+	var verified bool
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&verified)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	if !verified {
+		err = resetCredentials(ctx, tx, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	query = `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err = tx.ExecContext(ctx, query, userID, provider, subject, email)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		UPDATE users SET email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email_verified_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// resetCredentials signs the user out everywhere and removes every credential
+// they could sign in with
+func resetCredentials(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, `UPDATE users SET password_hash = NULL, updated_at = NOW() WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	err = revokeAllTokens(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		`DELETE FROM api_keys WHERE user_id = $1`,
+		`DELETE FROM passkeys WHERE user_id = $1`,
+		`DELETE FROM user_totp WHERE user_id = $1`,
+		`DELETE FROM mfa_recovery_codes WHERE user_id = $1`,
+		`DELETE FROM magic_link_tokens WHERE user_id = $1`,
+		`DELETE FROM password_reset_tokens WHERE user_id = $1`,
+	} {
+		_, err = tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateUserWithIdentity registers a new, already verified, user without a
+// password and links the provider account to it.
+func (s *IdentityStore) CreateUserWithIdentity(ctx context.Context, request RegisterRequest, provider string, subject string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO users (first_name, last_name, email, university_id, email_verified_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		RETURNING id
+	`
+
+	var userID int
+	err = tx.QueryRowContext(ctx, query, request.FirstName, request.LastName, request.Email, request.UniversityID).Scan(&userID)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		INSERT INTO user_identities (user_id, provider, subject, email)
+		VALUES ($1, $2, $3, $4)
+	`
+	_, err = tx.ExecContext(ctx, query, userID, provider, subject, request.Email)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -0,0 +1,171 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+type LoginFailures struct {
+	// Failures for the email since its last successful login
+	Account            int
+	AccountLastFailure time.Time
+	// Failures from the IP address across all emails
+	IP            int
+	IPLastFailure time.Time
+}
+
+// Advisory lock classes of BeginAttempt
+const (
+	loginAttemptEmailLock = 1
+	loginAttemptIPLock    = 2
+)
+
+// LoginAttempt is an attempt that got past the throttle. It is stored as a
+// failure until SucceedAttempt says otherwise, so attempts racing it already
+// count it against the email and IP address.
+type LoginAttempt struct {
+	ID int
+	// Failures made after since, this attempt included
+	Failures LoginFailures
+}
+
+// BeginAttempt records an attempt for the email from the IP address unless
+// retryAfter, given the failures made after since, says the client must wait.
+// Attempts for the same email or IP address are serialised, so parallel
+// guesses each see the ones before them.
+func (s *LoginAttemptStore) BeginAttempt(ctx context.Context, email string, ip string, since time.Time, retryAfter func(LoginFailures) time.Duration) (LoginAttempt, time.Duration, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return LoginAttempt{}, 0, err
+	}
+	defer tx.Rollback()
+
+	// The email lock is always taken first, attempts sharing only one of the
+	// keys cannot deadlock
+	query := `
+		SELECT pg_advisory_xact_lock($1, hashtext($2))
+	`
+	_, err = tx.ExecContext(ctx, query, loginAttemptEmailLock, email)
+	if err != nil {
+		return LoginAttempt{}, 0, err
+	}
+	_, err = tx.ExecContext(ctx, query, loginAttemptIPLock, ip)
+	if err != nil {
+		return LoginAttempt{}, 0, err
+	}
+
+	failures, err := getFailures(ctx, tx, email, ip, since)
+	if err != nil {
+		return LoginAttempt{}, 0, err
+	}
+
+	wait := retryAfter(failures)
+	if wait > 0 {
+		return LoginAttempt{}, wait, nil
+	}
+
+	query = `
+		INSERT INTO login_attempts (email, ip, succeeded)
+		VALUES ($1, $2, FALSE)
+		RETURNING id, attempted_at
+	`
+
+	var attempt LoginAttempt
+	var attemptedAt time.Time
+	err = tx.QueryRowContext(ctx, query, email, ip).Scan(&attempt.ID, &attemptedAt)
+	if err != nil {
+		return LoginAttempt{}, 0, err
+	}
+
+	attempt.Failures = LoginFailures{
+		Account:            failures.Account + 1,
+		AccountLastFailure: attemptedAt,
+		IP:                 failures.IP + 1,
+		IPLastFailure:      attemptedAt,
+	}
+
+	return attempt, 0, tx.Commit()
+}
+
+// SucceedAttempt marks an attempt successful, which clears the failures of
+// its email
+func (s *LoginAttemptStore) SucceedAttempt(ctx context.Context, attemptID int) error {
+	query := `
+		UPDATE login_attempts SET succeeded = TRUE WHERE id = $1
+	`
+
+	_, err := s.db.ExecContext(ctx, query, attemptID)
+	return err
+}
+
+// getFailures counts failed attempts made after since
+func getFailures(ctx context.Context, tx *sql.Tx, email string, ip string, since time.Time) (LoginFailures, error) {
+	query := `
+		SELECT COUNT(*), COALESCE(MAX(attempted_at), 'epoch')
+		FROM login_attempts
+		WHERE email = $1 AND NOT succeeded AND attempted_at > GREATEST($2, (
+			SELECT COALESCE(MAX(attempted_at), 'epoch') FROM login_attempts WHERE email = $1 AND succeeded
+		))
+	`
+
+	var failures LoginFailures
+	err := tx.QueryRowContext(ctx, query, email, since).Scan(&failures.Account, &failures.AccountLastFailure)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+
+	query = `
+		SELECT COUNT(*), COALESCE(MAX(attempted_at), 'epoch')
+		FROM login_attempts
+		WHERE ip = $1 AND NOT succeeded AND attempted_at > $2
+	`
+
+	err = tx.QueryRowContext(ctx, query, ip, since).Scan(&failures.IP, &failures.IPLastFailure)
+	if err != nil {
+		return LoginFailures{}, err
+	}
+
+	return failures, nil
+}
+
+// RecordLockout keeps an audit trail of lockouts. Scope is "account" or "ip",
+// the email is empty for IP lockouts. Nothing is recorded while the account or
+// IP is already locked, the returned bool reports whether a lockout was.
+func (s *LoginAttemptStore) RecordLockout(ctx context.Context, scope string, email string, ip string, failures int, lockedUntil time.Time) (bool, error) {
+	query := `
+		INSERT INTO login_lockouts (scope, email, ip, failures, locked_until)
+		SELECT $1::text, NULLIF($2::text, ''), $3::text, $4::int, $5::timestamptz
+		WHERE NOT EXISTS (
+			SELECT 1 FROM login_lockouts
+			WHERE scope = $1::text AND locked_until > NOW()
+			AND CASE WHEN $1::text = 'account' THEN email = $2::text ELSE ip = $3::text END
+		)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, scope, email, ip, failures, lockedUntil)
+	if err != nil {
+		return false, err
+	}
+
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return recorded > 0, nil
+}
+
+// DeleteAttemptsBefore prunes attempts too old to affect throttling
+func (s *LoginAttemptStore) DeleteAttemptsBefore(ctx context.Context, before time.Time) error {
+	query := `
+		DELETE FROM login_attempts WHERE attempted_at < $1
+	`
+
+	_, err := s.db.ExecContext(ctx, query, before)
+	return err
+}
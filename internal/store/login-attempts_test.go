@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+)
+
+func TestBeginAttemptThrottlesParallelGuesses(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	email := testdb.Email()
+	ip := testdb.Name("ip")
+	const allowed = 3
+
+	// Locks the email once it has allowed failures
+	retryAfter := func(failures LoginFailures) time.Duration {
+		if failures.Account >= allowed {
+			return time.Minute
+		}
+		return 0
+	}
+
+	var begun atomic.Int32
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, wait, err := s.LoginAttempts.BeginAttempt(ctx, email, ip, time.Now().Add(-time.Hour), retryAfter)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				begun.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := begun.Load(); got != allowed {
+		t.Fatalf("%d parallel attempts got past the throttle, want %d", got, allowed)
+	}
+}
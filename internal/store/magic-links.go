@@ -0,0 +1,132 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+type MagicLinkStore struct {
+	db *sql.DB
+}
+
+// CreateMagicLink issues a single use sign-in token for the user. When
+// codeChallenge is set the token can only be consumed with its verifier, tying
+// the link to the browser that asked for it.
+func (s *MagicLinkStore) CreateMagicLink(ctx context.Context, userID int, codeChallenge string, expiresAt time.Time) (string, error) {
+	token := rand.Text()
+
+	query := `
+		INSERT INTO magic_link_tokens (user_id, token_hash, code_challenge, expires_at)
+		VALUES ($1, $2, $3, $4)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, hashToken(token), codeChallenge, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// CountMagicLinksSince counts the links issued to the user after since
+func (s *MagicLinkStore) CountMagicLinksSince(ctx context.Context, userID int, since time.Time) (int, error) {
+	query := `
+		SELECT COUNT(*) FROM magic_link_tokens WHERE user_id = $1 AND created_at > $2
+	`
+
+	var count int
+	err := s.db.QueryRowContext(ctx, query, userID, since).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// ConsumeMagicLink uses up a sign-in token and returns its user. Every other
+// outstanding link of the user is invalidated with it, and since the user
+// proved they own the address their email is marked verified.
+//
+// As with LinkIdentity, an account whose email was never verified may have
+// been registered by someone else, so its credentials are reset before the
+// owner of the address is let in.
+//
+// A wrong verifier returns ErrLinkBindingMismatch and leaves the token usable,
+// so a stranger opening an intercepted link cannot burn it for its owner.
+func (s *MagicLinkStore) ConsumeMagicLink(ctx context.Context, token string, codeVerifier string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT user_id, code_challenge
+		FROM magic_link_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		FOR UPDATE
+	`
+
+	var userID int
+	var codeChallenge string
+	err = tx.QueryRowContext(ctx, query, hashToken(token)).Scan(&userID, &codeChallenge)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrInvalidToken
+		default:
+			return 0, err
+		}
+	}
+
+	if codeChallenge != "" {
+		sum := sha256.Sum256([]byte(codeVerifier))
+		expected := base64.RawURLEncoding.EncodeToString(sum[:])
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(codeChallenge)) != 1 {
+			return 0, ErrLinkBindingMismatch
+		}
+	}
+
+	query = `
+		SELECT email_verified_at IS NOT NULL FROM users WHERE id = $1 FOR UPDATE
+	`
+
+	var verified bool
+	err = tx.QueryRowContext(ctx, query, userID).Scan(&verified)
+	if err != nil {
+		return 0, err
+	}
+
+	if !verified {
+		err = resetCredentials(ctx, tx, userID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	query = `
+		UPDATE magic_link_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `
+		UPDATE users SET email_verified_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND email_verified_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+)
+
+func TestConsumeMagicLinkResetsUnverifiedAccount(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	// Someone registered the address first without being able to verify it
+	email := testdb.Email()
+
+	university, err := s.University.UniversityForEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := s.Auth.HashPassword("squatted password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := s.Auth.Register(ctx, RegisterRequest{
+		FirstName:    "Test",
+		LastName:     "User",
+		Email:        email,
+		UniversityID: university.ID,
+		PasswordHash: hash,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, key, err := s.APIKeys.CreateAPIKey(ctx, userID, "squatted key", []string{ScopeUserRead}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := s.MagicLinks.CreateMagicLink(ctx, userID, "", time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	consumedBy, err := s.MagicLinks.ConsumeMagicLink(ctx, token, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if consumedBy != userID {
+		t.Fatalf("link signed in user %d, want %d", consumedBy, userID)
+	}
+
+	user, err := s.User.GetUserByEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.PasswordHash != "" {
+		t.Error("the password set before the email was verified still works")
+	}
+	if !user.EmailVerified {
+		t.Error("email is not verified after redeeming the link")
+	}
+
+	_, err = s.APIKeys.GetAPIKey(ctx, key)
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("the API key created before the email was verified: got %v, want ErrNotFound", err)
+	}
+}
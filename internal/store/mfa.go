@@ -0,0 +1,249 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type MFAStore struct {
+	db *sql.DB
+}
+
+type TOTP struct {
+	UserID       int
+	Secret       string
+	Confirmed    bool
+	LastUsedStep int64
+}
+
+// IsEnabled reports whether the user has a confirmed TOTP authenticator
+func (s *MFAStore) IsEnabled(ctx context.Context, userID int) (bool, error) {
+	query := `
+		SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = $1 AND confirmed_at IS NOT NULL)
+	`
+
+	var exists bool
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+func (s *MFAStore) GetTOTP(ctx context.Context, userID int) (TOTP, error) {
+	query := `
+		SELECT user_id, secret, confirmed_at IS NOT NULL, COALESCE(last_used_step, 0)
+		FROM user_totp
+		WHERE user_id = $1
+	`
+
+	var totp TOTP
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&totp.UserID, &totp.Secret, &totp.Confirmed, &totp.LastUsedStep)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return TOTP{}, ErrNotFound
+		default:
+			return TOTP{}, err
+		}
+	}
+
+	return totp, nil
+}
+
+// EnrollTOTP stores a new unconfirmed secret, replacing any earlier enrollment
+// that was never confirmed. It fails with ErrConflict once TOTP is enabled.
+func (s *MFAStore) EnrollTOTP(ctx context.Context, userID int, secret string) error {
+	query := `
+		INSERT INTO user_totp (user_id, secret)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret = EXCLUDED.secret, last_used_step = NULL, created_at = NOW()
+		WHERE user_totp.confirmed_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+// UseStep records step as the latest accepted TOTP step. It returns false if
+// the step, or a later one, was already used.
+func (s *MFAStore) UseStep(ctx context.Context, userID int, step int64) (bool, error) {
+	query := `
+		UPDATE user_totp SET last_used_step = $2
+		WHERE user_id = $1 AND (last_used_step IS NULL OR last_used_step < $2)
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ConfirmTOTP enables TOTP and replaces the user's recovery codes
+func (s *MFAStore) ConfirmTOTP(ctx context.Context, userID int, recoveryCodes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE user_totp SET confirmed_at = NOW() WHERE user_id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *MFAStore) ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodes []string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseRecoveryCode consumes a matching unused recovery code
+func (s *MFAStore) UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	query := `
+		SELECT id, code_hash FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	matchedID := 0
+	for rows.Next() {
+		var id int
+		var hash string
+		err := rows.Scan(&id, &hash)
+		if err != nil {
+			return false, err
+		}
+
+		if bcrypt.CompareHashAndPassword([]byte(hash), []byte(code)) == nil {
+			matchedID = id
+			break
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	if matchedID == 0 {
+		return false, nil
+	}
+
+	// Guard on used_at so two concurrent requests cannot spend the same code
+	query = `
+		UPDATE mfa_recovery_codes SET used_at = NOW() WHERE id = $1 AND used_at IS NULL
+	`
+
+	result, err := s.db.ExecContext(ctx, query, matchedID)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+func (s *MFAStore) DisableTOTP(ctx context.Context, userID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		DELETE FROM user_totp WHERE user_id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		DELETE FROM mfa_recovery_codes WHERE user_id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceRecoveryCodes stores bcrypt hashes of the codes, they are short
+// enough that a fast hash could be brute forced from a database dump
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, recoveryCodes []string) error {
+	query := `
+		DELETE FROM mfa_recovery_codes WHERE user_id = $1
+	`
+	_, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `
+		INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)
+	`
+	for _, code := range recoveryCodes {
+		hash, err := bcrypt.GenerateFromPassword([]byte(code), bcrypt.DefaultCost)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, query, userID, string(hash))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/lib/pq"
+)
+
+// Passkey ceremonies
+const (
+	CeremonyRegistration = "registration"
+	CeremonyLogin        = "login"
+)
+
+type PasskeyStore struct {
+	db *sql.DB
+}
+
+type Passkey struct {
+	ID         int                 `json:"id"`
+	UserID     int                 `json:"-"`
+	Name       string              `json:"name"`
+	Credential webauthn.Credential `json:"-"`
+	LastUsedAt *time.Time          `json:"last_used_at"`
+	CreatedAt  time.Time           `json:"created_at"`
+}
+
+// CreateCeremony remembers the challenge of a started registration or login
+// until the browser finishes it. It returns the id the browser echoes back,
+// only its hash is stored. userID is 0 for logins.
+func (s *PasskeyStore) CreateCeremony(ctx context.Context, ceremony string, userID int, session webauthn.SessionData, expiresAt time.Time) (string, error) {
+	id := rand.Text()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	query := `
+		INSERT INTO passkey_ceremonies (id_hash, ceremony, user_id, session_data, expires_at)
+		VALUES ($1, $2, NULLIF($3, 0), $4, $5)
+	`
+
+	_, err = s.db.ExecContext(ctx, query, hashToken(id), ceremony, userID, data, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return id, nil
+}
+
+// ConsumeCeremony deletes and returns a started ceremony, so each challenge can
+// only be answered once
+func (s *PasskeyStore) ConsumeCeremony(ctx context.Context, id string, ceremony string, userID int) (webauthn.SessionData, error) {
+	// Clear out abandoned ceremonies while we are here
+	query := `
+		DELETE FROM passkey_ceremonies WHERE expires_at < NOW()
+	`
+	_, err := s.db.ExecContext(ctx, query)
+	if err != nil {
+		return webauthn.SessionData{}, err
+	}
+
+	query = `
+		DELETE FROM passkey_ceremonies
+		WHERE id_hash = $1 AND ceremony = $2 AND COALESCE(user_id, 0) = $3
+		RETURNING session_data
+	`
+
+	var data []byte
+	err = s.db.QueryRowContext(ctx, query, hashToken(id), ceremony, userID).Scan(&data)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return webauthn.SessionData{}, ErrInvalidToken
+		default:
+			return webauthn.SessionData{}, err
+		}
+	}
+
+	var session webauthn.SessionData
+	err = json.Unmarshal(data, &session)
+	if err != nil {
+		return webauthn.SessionData{}, err
+	}
+
+	return session, nil
+}
+
+func (s *PasskeyStore) GetPasskeys(ctx context.Context, userID int) ([]Passkey, error) {
+	query := `
+		SELECT id, user_id, name, credential, last_used_at, created_at
+		FROM passkeys
+		WHERE user_id = $1
+		ORDER BY created_at
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passkeys := []Passkey{}
+	for rows.Next() {
+		var passkey Passkey
+		var credential []byte
+		err := rows.Scan(&passkey.ID, &passkey.UserID, &passkey.Name, &credential, &passkey.LastUsedAt, &passkey.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		err = json.Unmarshal(credential, &passkey.Credential)
+		if err != nil {
+			return nil, err
+		}
+
+		passkeys = append(passkeys, passkey)
+	}
+
+	return passkeys, rows.Err()
+}
+
+// AddPasskey stores a newly registered credential. It fails with ErrConflict if
+// the credential is already registered, to this or any other user.
+func (s *PasskeyStore) AddPasskey(ctx context.Context, userID int, name string, credential webauthn.Credential) (Passkey, error) {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return Passkey{}, err
+	}
+
+	passkey := Passkey{
+		UserID:     userID,
+		Name:       name,
+		Credential: credential,
+	}
+
+	query := `
+		INSERT INTO passkeys (user_id, name, credential_id, credential)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`
+
+	err = s.db.QueryRowContext(ctx, query, userID, name, credential.ID, data).Scan(&passkey.ID, &passkey.CreatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return Passkey{}, ErrConflict
+		}
+		return Passkey{}, err
+	}
+
+	return passkey, nil
+}
+
+// UsePasskey saves the credential state after a successful login, most
+// importantly its new sign count
+func (s *PasskeyStore) UsePasskey(ctx context.Context, credential webauthn.Credential) error {
+	data, err := json.Marshal(credential)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE passkeys SET credential = $1, last_used_at = NOW()
+		WHERE credential_id = $2
+	`
+
+	_, err = s.db.ExecContext(ctx, query, data, credential.ID)
+	return err
+}
+
+func (s *PasskeyStore) DeletePasskey(ctx context.Context, userID int, passkeyID int) error {
+	query := `
+		DELETE FROM passkeys WHERE id = $1 AND user_id = $2
+	`
+
+	result, err := s.db.ExecContext(ctx, query, passkeyID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+type PasswordResetStore struct {
+	db *sql.DB
+}
+
+// CreateResetToken issues a single use password reset token for the user. Only
+// its hash is stored, the raw token is returned to be emailed.
+func (s *PasswordResetStore) CreateResetToken(ctx context.Context, userID int, expiresAt time.Time) (string, error) {
+	token := rand.Text()
+
+	query := `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, hashToken(token), expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// ResetPassword consumes a reset token and sets the new password hash. Every
+// other reset token and every session of the user is revoked with it.
+func (s *PasswordResetStore) ResetPassword(ctx context.Context, token string, passwordHash string) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > NOW()
+		RETURNING user_id
+	`
+
+	var userID int
+	err = tx.QueryRowContext(ctx, query, hashToken(token)).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrInvalidToken
+		default:
+			return 0, err
+		}
+	}
+
+	query = `
+		UPDATE password_reset_tokens SET used_at = NOW()
+		WHERE user_id = $1 AND used_at IS NULL
+	`
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setPassword(ctx, tx, userID, passwordHash)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
+
+// ChangePassword sets a new password hash and revokes every session of the user
+func (s *PasswordResetStore) ChangePassword(ctx context.Context, userID int, passwordHash string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = setPassword(ctx, tx, userID, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func setPassword(ctx context.Context, tx *sql.Tx, userID int, passwordHash string) error {
+	query := `
+		UPDATE users SET password_hash = $1, updated_at = NOW() WHERE id = $2
+	`
+
+	_, err := tx.ExecContext(ctx, query, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+
+	return revokeAllTokens(ctx, tx, userID)
+}
@@ -42,7 +42,7 @@ func (s *SessionStore) GetUserStudySessions(ctx context.Context, userID int) ([]
 		FROM study_sessions s
 		INNER JOIN groups g ON s.group_id = g.id
 		INNER JOIN membership m ON s.group_id = m.group_id
-		WHERE m.user_id = $1 AND m.role IN ('member', 'admin') AND s.end_time > NOW()
+		WHERE m.user_id = $1 AND s.end_time > NOW()
 	`
 
 	rows, err := s.db.QueryContext(ctx, query, userID)
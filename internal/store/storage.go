@@ -6,31 +6,120 @@ import (
 	"errors"
 	"time"
 
+	"github.com/RakibulBh/studygroup-backend/internal/signing"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/golang-jwt/jwt/v5"
 )
 
 // Errors
 var (
-	ErrNotFound = errors.New("not found")
-	ErrNoRows   = errors.New("user not found")
-	ErrConflict = errors.New("conflict")
-	ErrInternal = errors.New("internal server error")
-	ErrInvalid  = errors.New("invalid input")
+	ErrNotFound            = errors.New("not found")
+	ErrNoRows              = errors.New("user not found")
+	ErrConflict            = errors.New("conflict")
+	ErrInternal            = errors.New("internal server error")
+	ErrInvalid             = errors.New("invalid input")
+	ErrInvalidToken        = errors.New("invalid or revoked token")
+	ErrTokenReused         = errors.New("refresh token reuse detected")
+	ErrLinkBindingMismatch = errors.New("link was requested from a different browser")
+	ErrBlocked             = errors.New("blocked by the other user")
+	ErrBanned              = errors.New("banned from the group")
 )
 
 type Storage struct {
 	Auth interface {
 		HashPassword(password string) (string, error)
-		Register(ctx context.Context, request RegisterRequest) error
+		Register(ctx context.Context, request RegisterRequest) (int, error)
+		VerifyEmail(ctx context.Context, userID int) error
 		VerifyPassword(password string, hash string) (bool, error)
-		GenerateJWT(userID int, expiresAt time.Time, secret string) (string, error)
-		VerifyToken(tokenString string, secret string) (*jwt.Token, error)
-		StoreRefreshToken(ctx context.Context, userID int, token string, expiresAt time.Time) error
-		RefreshToken(ctx context.Context, userID int, tokenString string, secret string, refreshExp time.Duration, accessExp time.Duration) (string, string, error)
+		GenerateJWT(userID int, sessionID string, tokenType string, expiresAt time.Time, keys *signing.KeySet) (string, error)
+		VerifyToken(tokenString string, keys *signing.KeySet) (*jwt.Token, error)
+		GenerateActionToken(userID int, action string, expiresAt time.Time, keys *signing.KeySet) (string, error)
+		VerifyActionToken(tokenString string, action string, keys *signing.KeySet) (int, error)
+		StoreRefreshToken(ctx context.Context, userID int, familyID string, token string, expiresAt time.Time, device Device) error
+		RefreshToken(ctx context.Context, userID int, tokenString string, keys *signing.KeySet, refreshExp time.Duration, accessExp time.Duration, device Device) (string, string, error)
+		RevokeRefreshToken(ctx context.Context, userID int, tokenString string) error
+		RevokeAllTokens(ctx context.Context, userID int) error
+		IsTokenRevoked(ctx context.Context, userID int, sessionID string, issuedAt time.Time) (bool, error)
+		ListSessions(ctx context.Context, userID int) ([]AuthSession, error)
+		RevokeSession(ctx context.Context, userID int, sessionID string) error
+		TouchSession(ctx context.Context, sessionID string, device Device) error
+	}
+	Account interface {
+		ExportAccountData(ctx context.Context, userID int) (AccountData, error)
+		GetDeletionSchedule(ctx context.Context, userID int) (*time.Time, error)
+		ScheduleDeletion(ctx context.Context, userID int, at time.Time) (time.Time, error)
+		CancelDeletion(ctx context.Context, userID int) error
+		GetAccountsDueForDeletion(ctx context.Context, before time.Time, limit int) ([]int, error)
+		DeleteAccount(ctx context.Context, userID int) ([]string, error)
+	}
+	APIKeys interface {
+		CreateAPIKey(ctx context.Context, userID int, name string, scopes []string, expiresAt *time.Time) (APIKey, string, error)
+		ListAPIKeys(ctx context.Context, userID int) ([]APIKey, error)
+		GetAPIKey(ctx context.Context, key string) (APIKey, error)
+		TouchAPIKey(ctx context.Context, keyID int) error
+		RevokeAPIKey(ctx context.Context, userID int, keyID int) error
+	}
+	Identity interface {
+		CreateLoginState(ctx context.Context, state string, provider string, verifier string, nonce string, expiresAt time.Time) error
+		ConsumeLoginState(ctx context.Context, state string, provider string) (string, string, error)
+		GetUserIDByIdentity(ctx context.Context, provider string, subject string) (int, error)
+		LinkIdentity(ctx context.Context, userID int, provider string, subject string, email string) error
+		CreateUserWithIdentity(ctx context.Context, request RegisterRequest, provider string, subject string) (int, error)
+	}
+	LoginAttempts interface {
+		BeginAttempt(ctx context.Context, email string, ip string, since time.Time, retryAfter func(LoginFailures) time.Duration) (LoginAttempt, time.Duration, error)
+		SucceedAttempt(ctx context.Context, attemptID int) error
+		RecordLockout(ctx context.Context, scope string, email string, ip string, failures int, lockedUntil time.Time) (bool, error)
+		DeleteAttemptsBefore(ctx context.Context, before time.Time) error
+	}
+	MagicLinks interface {
+		CreateMagicLink(ctx context.Context, userID int, codeChallenge string, expiresAt time.Time) (string, error)
+		CountMagicLinksSince(ctx context.Context, userID int, since time.Time) (int, error)
+		ConsumeMagicLink(ctx context.Context, token string, codeVerifier string) (int, error)
+	}
+	MFA interface {
+		IsEnabled(ctx context.Context, userID int) (bool, error)
+		GetTOTP(ctx context.Context, userID int) (TOTP, error)
+		EnrollTOTP(ctx context.Context, userID int, secret string) error
+		UseStep(ctx context.Context, userID int, step int64) (bool, error)
+		ConfirmTOTP(ctx context.Context, userID int, recoveryCodes []string) error
+		ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodes []string) error
+		UseRecoveryCode(ctx context.Context, userID int, code string) (bool, error)
+		DisableTOTP(ctx context.Context, userID int) error
+	}
+	Passkeys interface {
+		CreateCeremony(ctx context.Context, ceremony string, userID int, session webauthn.SessionData, expiresAt time.Time) (string, error)
+		ConsumeCeremony(ctx context.Context, id string, ceremony string, userID int) (webauthn.SessionData, error)
+		GetPasskeys(ctx context.Context, userID int) ([]Passkey, error)
+		AddPasskey(ctx context.Context, userID int, name string, credential webauthn.Credential) (Passkey, error)
+		UsePasskey(ctx context.Context, credential webauthn.Credential) error
+		DeletePasskey(ctx context.Context, userID int, passkeyID int) error
+	}
+	PasswordReset interface {
+		CreateResetToken(ctx context.Context, userID int, expiresAt time.Time) (string, error)
+		ResetPassword(ctx context.Context, token string, passwordHash string) (int, error)
+		ChangePassword(ctx context.Context, userID int, passwordHash string) error
+	}
+	University interface {
+		ListUniversities(ctx context.Context, search string, limit int) ([]University, error)
+		GetUniversity(ctx context.Context, id int) (University, error)
+		UniversityForEmail(ctx context.Context, email string) (University, error)
+		CreateUniversity(ctx context.Context, university *University) error
+		MergeUniversities(ctx context.Context, sourceID int, targetID int) (University, error)
+	}
+	UserBlocks interface {
+		ListBlocks(ctx context.Context, userID int, kind string) ([]BlockedUser, error)
+		AddBlock(ctx context.Context, userID int, targetID int, kind string) error
+		RemoveBlock(ctx context.Context, userID int, targetID int, kind string) error
+		IsBlocked(ctx context.Context, userID int, otherID int) (bool, error)
 	}
 	User interface {
 		GetUserByID(ctx context.Context, id int) (User, error)
 		GetUserByEmail(ctx context.Context, email string) (UserData, error)
+		GetProfile(ctx context.Context, userID int) (UserProfile, error)
+		SearchUsers(ctx context.Context, viewer User, search string, limit int, offset int) ([]UserProfile, error)
+		UpdateProfile(ctx context.Context, profile UserProfile) error
+		SetAvatar(ctx context.Context, userID int, key string) (string, error)
 	}
 	GroupRepository interface {
 		GetAllGroups(ctx context.Context) ([]Group, error)
@@ -39,30 +128,48 @@ type Storage struct {
 		GetUserGroups(ctx context.Context, userID int) ([]Group, error)
 		SearchGroup(ctx context.Context, searchQuery string) ([]Group, error)
 		GetJoinedGroups(ctx context.Context, userID int) ([]Group, error)
-		DeleteGroup(ctx context.Context, groupID int) error
+		UpdateGroup(ctx context.Context, groupID int, patch GroupPatch, changedBy int, validate func(*Group) error) (Group, []GroupChange, error)
+		GetGroupChanges(ctx context.Context, groupID int, field string, beforeID int, limit int) ([]GroupChange, error)
+		DeleteGroup(ctx context.Context, groupID int) (string, error)
+		SetCoverImage(ctx context.Context, groupID int, key string) (string, error)
 	}
 	GroupJoinRequests interface {
 		JoinRequest(ctx context.Context, groupID int, userID int) error
-		GetJoinRequests(ctx context.Context, groupID int) ([]GroupJoinRequest, error)
+		GetJoinRequests(ctx context.Context, groupID int, viewerID int) ([]GroupJoinRequest, error)
 		IsJoinRequested(ctx context.Context, groupID int, userID int) (bool, error)
-		ApproveJoinRequest(ctx context.Context, groupID int, userID int) error
+		ApproveJoinRequest(ctx context.Context, groupID int, userID int) (bool, error)
 		RejectJoinRequest(ctx context.Context, groupID int, userID int) error
+		NotifyWaitlistPromotion(ctx context.Context, afterID int, notify func(WaitlistPromotion) error) (int, error)
 	}
 	GroupInvitations interface {
-		InviteUserToGroup(ctx context.Context, groupID int, userID int) error
-		AcceptInvitation(ctx context.Context, userID int, groupID int) error
+		InviteUserToGroup(ctx context.Context, groupID int, userID int, invitedBy int) error
+		AcceptInvitation(ctx context.Context, userID int, groupID int) (bool, error)
 		GetInvitations(ctx context.Context, userID int) ([]GroupInvitation, error)
 		RejectInvitation(ctx context.Context, userID int, groupID int) error
 	}
 	GroupMembership interface {
 		IsMember(ctx context.Context, groupID int, userID int) (bool, error)
-		IsAdmin(ctx context.Context, groupID int, userID int) (bool, error)
+		GetRole(ctx context.Context, groupID int, userID int) (string, error)
 		GetMemberCount(ctx context.Context, groupID int) (int, error)
-		GetGroupMembers(ctx context.Context, groupID int) ([]User, error)
+		GetGroupMembers(ctx context.Context, groupID int, viewerID int) ([]GroupMember, error)
 	}
 	GroupMembershipManagement interface {
 		LeaveGroup(ctx context.Context, groupID int, userID int) error
-		MakeAdmin(ctx context.Context, groupID int, userID int) error
+		AddOwner(ctx context.Context, groupID int, userID int) error
+		ChangeRole(ctx context.Context, groupID int, userID int, role string) error
+		OfferOwnership(ctx context.Context, groupID int, fromUserID int, toUserID int) (OwnershipTransfer, error)
+		GetOwnershipTransfer(ctx context.Context, groupID int) (OwnershipTransfer, error)
+		GetOwnershipOffers(ctx context.Context, userID int) ([]OwnershipTransfer, error)
+		CancelOwnershipTransfer(ctx context.Context, groupID int) error
+		DeclineOwnershipTransfer(ctx context.Context, groupID int, userID int) error
+		AcceptOwnershipTransfer(ctx context.Context, groupID int, userID int) error
+		RemoveMember(ctx context.Context, groupID int, userID int, byRole string) error
+	}
+	GroupBans interface {
+		BanUser(ctx context.Context, ban *GroupBan, byRole string) error
+		Unban(ctx context.Context, groupID int, userID int) error
+		ListBans(ctx context.Context, groupID int) ([]GroupBan, error)
+		IsBanned(ctx context.Context, groupID int, userID int) (bool, error)
 	}
 	Session interface {
 		CreateStudySession(ctx context.Context, session *StudySession) (int, error)
@@ -74,12 +181,23 @@ type Storage struct {
 func NewStorage(db *sql.DB) Storage {
 	return Storage{
 		Auth:                      &AuthStore{db: db},
+		Account:                   &AccountStore{db: db},
+		APIKeys:                   &APIKeyStore{db: db},
+		Identity:                  &IdentityStore{db: db},
+		LoginAttempts:             &LoginAttemptStore{db: db},
+		MagicLinks:                &MagicLinkStore{db: db},
+		MFA:                       &MFAStore{db: db},
+		Passkeys:                  &PasskeyStore{db: db},
+		PasswordReset:             &PasswordResetStore{db: db},
+		University:                &UniversityStore{db: db},
 		User:                      &UserStore{db: db},
+		UserBlocks:                &UserBlockStore{db: db},
 		GroupRepository:           &GroupRepository{db: db},
 		GroupJoinRequests:         &GroupJoinRequestsStore{db: db},
 		GroupInvitations:          &GroupInvitationsStore{db: db},
 		GroupMembership:           &GroupMembershipStore{db: db},
 		GroupMembershipManagement: &GroupMembershipManagementStore{db: db},
+		GroupBans:                 &GroupBanStore{db: db},
 		Session:                   &SessionStore{db: db},
 	}
 }
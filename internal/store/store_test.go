@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RakibulBh/studygroup-backend/internal/testdb"
+)
+
+// newTestStorage connects to the test database, it is skipped when there is none
+func newTestStorage(t *testing.T) Storage {
+	t.Helper()
+
+	return NewStorage(testdb.Open(t))
+}
+
+func createTestUser(t *testing.T, s Storage) int {
+	t.Helper()
+
+	ctx := context.Background()
+	email := testdb.Email()
+
+	university, err := s.University.UniversityForEmail(ctx, email)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userID, err := s.Auth.Register(ctx, RegisterRequest{
+		FirstName:    "Test",
+		LastName:     "User",
+		Email:        email,
+		UniversityID: university.ID,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return userID
+}
+
+// createTestGroup creates a group owned by a new user, a memberLimit of 0
+// means no limit
+func createTestGroup(t *testing.T, s Storage, memberLimit int) (int, int) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	groupID, err := s.GroupRepository.CreateGroup(ctx, &Group{
+		Name:           testdb.Name("group"),
+		Description:    "A test group",
+		HasMemberLimit: memberLimit > 0,
+		MemberLimit:    memberLimit,
+		Subject:        "Testing",
+		Location:       "Online",
+		Visibility:     "public",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ownerID := createTestUser(t, s)
+	err = s.GroupMembershipManagement.AddOwner(ctx, groupID, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return groupID, ownerID
+}
+
+// addTestMember adds a new user to the group through an approved join request
+func addTestMember(t *testing.T, s Storage, groupID int) int {
+	t.Helper()
+
+	ctx := context.Background()
+	userID := createTestUser(t, s)
+
+	err := s.GroupJoinRequests.JoinRequest(ctx, groupID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = s.GroupJoinRequests.ApproveJoinRequest(ctx, groupID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return userID
+}
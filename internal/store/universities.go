@@ -0,0 +1,262 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// OtherUniversityName is the catch-all university for emails no other
+// university claims
+const OtherUniversityName = "Other"
+
+type UniversityStore struct {
+	db *sql.DB
+}
+
+type University struct {
+	ID      int    `json:"id"`
+	Name    string `json:"name"`
+	Country string `json:"country"`
+	// EmailDomains lists the email domains issued by the university.
+	// Subdomains (e.g. student.brunel.ac.uk) are accepted too. An empty list
+	// means any email address may claim the university.
+	EmailDomains []string  `json:"email_domains"`
+	Timezone     string    `json:"timezone"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// AllowsEmail reports whether the email address belongs to one of the
+// university's domains
+func (u University) AllowsEmail(email string) bool {
+	if len(u.EmailDomains) == 0 {
+		return true
+	}
+
+	domain := emailDomain(email)
+	if domain == "" {
+		return false
+	}
+
+	return slices.ContainsFunc(u.EmailDomains, func(allowed string) bool {
+		return domain == allowed || strings.HasSuffix(domain, "."+allowed)
+	})
+}
+
+// escapeLike makes the wildcards in user input match literally in a LIKE pattern
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func emailDomain(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return ""
+	}
+
+	return strings.ToLower(email[at+1:])
+}
+
+const universityColumns = `id, name, country, email_domains, timezone, created_at, updated_at`
+
+func scanUniversity(row interface{ Scan(dest ...any) error }, university *University) error {
+	return row.Scan(&university.ID, &university.Name, &university.Country, pq.Array(&university.EmailDomains), &university.Timezone, &university.CreatedAt, &university.UpdatedAt)
+}
+
+// ListUniversities returns up to limit universities whose name contains search,
+// alphabetically
+func (s *UniversityStore) ListUniversities(ctx context.Context, search string, limit int) ([]University, error) {
+	query := `
+		SELECT ` + universityColumns + `
+		FROM universities
+		WHERE name ILIKE '%' || $1 || '%'
+		ORDER BY name
+		LIMIT $2
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, escapeLike(search), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	universities := []University{}
+	for rows.Next() {
+		var university University
+		err := scanUniversity(rows, &university)
+		if err != nil {
+			return nil, err
+		}
+
+		universities = append(universities, university)
+	}
+
+	return universities, rows.Err()
+}
+
+func (s *UniversityStore) GetUniversity(ctx context.Context, id int) (University, error) {
+	query := `
+		SELECT ` + universityColumns + ` FROM universities WHERE id = $1
+	`
+
+	var university University
+	err := scanUniversity(s.db.QueryRowContext(ctx, query, id), &university)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return University{}, ErrNotFound
+		default:
+			return University{}, err
+		}
+	}
+
+	return university, nil
+}
+
+// UniversityForEmail picks the university whose domains include the email
+// address, falling back to Other
+func (s *UniversityStore) UniversityForEmail(ctx context.Context, email string) (University, error) {
+	query := `
+		SELECT ` + universityColumns + `
+		FROM universities
+		WHERE name = $2 OR EXISTS (
+			SELECT 1 FROM unnest(email_domains) AS allowed
+			WHERE $1 = allowed OR $1 LIKE '%.' || allowed
+		)
+		ORDER BY name = $2, id
+		LIMIT 1
+	`
+
+	var university University
+	err := scanUniversity(s.db.QueryRowContext(ctx, query, emailDomain(email), OtherUniversityName), &university)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return University{}, ErrNotFound
+		default:
+			return University{}, err
+		}
+	}
+
+	return university, nil
+}
+
+// CreateUniversity adds a university to the catalog. A name that is taken or
+// an email domain another university already claims returns ErrConflict.
+func (s *UniversityStore) CreateUniversity(ctx context.Context, university *University) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialises catalog changes so two universities cannot claim the same
+	// domain at once
+	_, err = tx.ExecContext(ctx, `LOCK TABLE universities IN SHARE ROW EXCLUSIVE MODE`)
+	if err != nil {
+		return err
+	}
+
+	var claimed bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM universities WHERE email_domains && $1)`, pq.Array(university.EmailDomains)).Scan(&claimed)
+	if err != nil {
+		return err
+	}
+	if claimed {
+		return ErrConflict
+	}
+
+	query := `
+		INSERT INTO universities (name, country, email_domains, timezone)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at, updated_at
+	`
+
+	err = tx.QueryRowContext(ctx, query, university.Name, university.Country, pq.Array(university.EmailDomains), university.Timezone).Scan(&university.ID, &university.CreatedAt, &university.UpdatedAt)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return ErrConflict
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergeUniversities folds the source university into the target. Its users
+// move over, its email domains are added to the target's and the source is
+// removed. It returns the updated target.
+func (s *UniversityStore) MergeUniversities(ctx context.Context, sourceID int, targetID int) (University, error) {
+	if sourceID == targetID {
+		return University{}, ErrInvalid
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return University{}, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT id FROM universities WHERE id IN ($1, $2) ORDER BY id FOR UPDATE
+	`
+
+	rows, err := tx.QueryContext(ctx, query, sourceID, targetID)
+	if err != nil {
+		return University{}, err
+	}
+	found := 0
+	for rows.Next() {
+		found++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return University{}, err
+	}
+	if found != 2 {
+		return University{}, ErrNotFound
+	}
+
+	query = `
+		UPDATE users SET university_id = $2, updated_at = NOW() WHERE university_id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, sourceID, targetID)
+	if err != nil {
+		return University{}, err
+	}
+
+	query = `
+		UPDATE universities t
+		SET email_domains = ARRAY(SELECT DISTINCT unnest(t.email_domains || s.email_domains) ORDER BY 1),
+			updated_at = NOW()
+		FROM universities s
+		WHERE t.id = $2 AND s.id = $1
+	`
+	_, err = tx.ExecContext(ctx, query, sourceID, targetID)
+	if err != nil {
+		return University{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM universities WHERE id = $1`, sourceID)
+	if err != nil {
+		return University{}, err
+	}
+
+	var target University
+	query = `
+		SELECT ` + universityColumns + ` FROM universities WHERE id = $1
+	`
+	err = scanUniversity(tx.QueryRowContext(ctx, query, targetID), &target)
+	if err != nil {
+		return University{}, err
+	}
+
+	return target, tx.Commit()
+}
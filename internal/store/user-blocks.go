@@ -0,0 +1,127 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Kinds of restriction a user can put on another. A block cuts contact both
+// ways: neither user finds the other in search or sees their profile, the
+// blocked user cannot invite the blocker, and the blocker no longer sees them
+// in member lists or join requests. A mute is one sided and silent, the muted
+// user's invitations and join requests are hidden from the muter only.
+const (
+	BlockKindBlock = "block"
+	BlockKindMute  = "mute"
+)
+
+type UserBlockStore struct {
+	db *sql.DB
+}
+
+// BlockedUser is an entry in a user's block or mute list
+type BlockedUser struct {
+	UserID    int       `json:"user_id"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (s *UserBlockStore) ListBlocks(ctx context.Context, userID int, kind string) ([]BlockedUser, error) {
+	query := `
+		SELECT u.id, u.first_name, u.last_name, b.created_at
+		FROM user_blocks b
+		JOIN users u ON u.id = b.blocked_user_id
+		WHERE b.user_id = $1 AND b.kind = $2
+		ORDER BY b.created_at DESC
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, userID, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	blocked := []BlockedUser{}
+	for rows.Next() {
+		var user BlockedUser
+		err := rows.Scan(&user.UserID, &user.FirstName, &user.LastName, &user.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		blocked = append(blocked, user)
+	}
+
+	return blocked, rows.Err()
+}
+
+// AddBlock blocks or mutes the target user. Doing it twice is not an error. An
+// unknown target returns ErrNotFound.
+func (s *UserBlockStore) AddBlock(ctx context.Context, userID int, targetID int, kind string) error {
+	if userID == targetID {
+		return ErrInvalid
+	}
+
+	query := `
+		INSERT INTO user_blocks (user_id, blocked_user_id, kind)
+		VALUES ($1, $2, $3)
+		ON CONFLICT DO NOTHING
+	`
+
+	_, err := s.db.ExecContext(ctx, query, userID, targetID, kind)
+	if err != nil {
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23503" {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	return nil
+}
+
+// RemoveBlock lifts a block or mute, returning ErrNotFound when there was none
+func (s *UserBlockStore) RemoveBlock(ctx context.Context, userID int, targetID int, kind string) error {
+	query := `
+		DELETE FROM user_blocks WHERE user_id = $1 AND blocked_user_id = $2 AND kind = $3
+	`
+
+	result, err := s.db.ExecContext(ctx, query, userID, targetID, kind)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// IsBlocked reports whether either user has blocked the other
+func (s *UserBlockStore) IsBlocked(ctx context.Context, userID int, otherID int) (bool, error) {
+	query := `
+		SELECT EXISTS(
+			SELECT 1 FROM user_blocks
+			WHERE kind = 'block'
+				AND ((user_id = $1 AND blocked_user_id = $2) OR (user_id = $2 AND blocked_user_id = $1))
+		)
+	`
+
+	var blocked bool
+	err := s.db.QueryRowContext(ctx, query, userID, otherID).Scan(&blocked)
+	if err != nil {
+		return false, err
+	}
+
+	return blocked, nil
+}
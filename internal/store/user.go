@@ -4,7 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"slices"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type UserStore struct {
@@ -12,34 +15,159 @@ type UserStore struct {
 }
 
 type UserData struct {
-	ID           int
-	FirstName    string
-	LastName     string
-	Email        string
-	University   string
-	PasswordHash string
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
+	ID            int
+	FirstName     string
+	LastName      string
+	Email         string
+	UniversityID  int
+	University    string
+	PasswordHash  string
+	EmailVerified bool
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
 }
 
 type User struct {
-	ID         int    `json:"id"`
-	FirstName  string `json:"first_name"`
-	LastName   string `json:"last_name"`
-	Email      string `json:"email"`
-	University string `json:"university"`
+	ID            int    `json:"id"`
+	FirstName     string `json:"first_name"`
+	LastName      string `json:"last_name"`
+	Email         string `json:"email"`
+	UniversityID  int    `json:"university_id"`
+	University    string `json:"university"`
+	EmailVerified bool   `json:"email_verified"`
+	SiteAdmin     bool   `json:"site_admin"`
+}
+
+// Profile visibilities, who may see a user's public profile
+const (
+	ProfileVisibilityPublic     = "public"
+	ProfileVisibilityUniversity = "university"
+	ProfileVisibilityPrivate    = "private"
+)
+
+// Optional profile fields a user can choose to share. Names are always shown.
+const (
+	ProfileFieldUniversity  = "university"
+	ProfileFieldBio         = "bio"
+	ProfileFieldPronouns    = "pronouns"
+	ProfileFieldYearOfStudy = "year_of_study"
+	ProfileFieldCourse      = "course"
+	ProfileFieldModules     = "modules"
+)
+
+var ProfileFields = []string{
+	ProfileFieldUniversity,
+	ProfileFieldBio,
+	ProfileFieldPronouns,
+	ProfileFieldYearOfStudy,
+	ProfileFieldCourse,
+	ProfileFieldModules,
+}
+
+// UserProfile is the editable part of a user along with their privacy settings
+type UserProfile struct {
+	ID           int      `json:"id"`
+	FirstName    string   `json:"first_name"`
+	LastName     string   `json:"last_name"`
+	UniversityID int      `json:"university_id"`
+	University   string   `json:"university"`
+	Bio          string   `json:"bio"`
+	Pronouns     string   `json:"pronouns"`
+	YearOfStudy  *int     `json:"year_of_study"`
+	Course       string   `json:"course"`
+	Modules      []string `json:"modules"`
+	Visibility   string   `json:"profile_visibility"`
+	SharedFields []string `json:"shared_fields"`
+	// AvatarKey is the blob storage key of the avatar, the URLs are filled in
+	// by the API
+	AvatarKey          string `json:"-"`
+	AvatarURL          string `json:"avatar_url,omitempty"`
+	AvatarThumbnailURL string `json:"avatar_thumbnail_url,omitempty"`
+}
+
+// Shares reports whether the user chose to show the field on their public profile
+func (p UserProfile) Shares(field string) bool {
+	return slices.Contains(p.SharedFields, field)
+}
+
+// PublicProfile is what other users see of a profile
+type PublicProfile struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	// UniversityID and University are only set when the user shares them
+	UniversityID int      `json:"university_id,omitempty"`
+	University   string   `json:"university,omitempty"`
+	Bio          string   `json:"bio,omitempty"`
+	Pronouns     string   `json:"pronouns,omitempty"`
+	YearOfStudy  *int     `json:"year_of_study,omitempty"`
+	Course       string   `json:"course,omitempty"`
+	Modules      []string `json:"modules,omitempty"`
+	// Avatars are shown like names, on every profile the viewer can see
+	AvatarURL          string `json:"avatar_url,omitempty"`
+	AvatarThumbnailURL string `json:"avatar_thumbnail_url,omitempty"`
+}
+
+// Public strips the profile down to the name and the fields the user shares
+func (p UserProfile) Public() PublicProfile {
+	public := PublicProfile{
+		ID:                 p.ID,
+		FirstName:          p.FirstName,
+		LastName:           p.LastName,
+		AvatarURL:          p.AvatarURL,
+		AvatarThumbnailURL: p.AvatarThumbnailURL,
+	}
+
+	if p.Shares(ProfileFieldUniversity) {
+		public.UniversityID = p.UniversityID
+		public.University = p.University
+	}
+	if p.Shares(ProfileFieldBio) {
+		public.Bio = p.Bio
+	}
+	if p.Shares(ProfileFieldPronouns) {
+		public.Pronouns = p.Pronouns
+	}
+	if p.Shares(ProfileFieldYearOfStudy) {
+		public.YearOfStudy = p.YearOfStudy
+	}
+	if p.Shares(ProfileFieldCourse) {
+		public.Course = p.Course
+	}
+	if p.Shares(ProfileFieldModules) {
+		public.Modules = p.Modules
+	}
+
+	return public
+}
+
+// VisibleTo reports whether the viewer may see the profile at all
+func (p UserProfile) VisibleTo(viewer User) bool {
+	if viewer.ID == p.ID {
+		return true
+	}
+
+	switch p.Visibility {
+	case ProfileVisibilityPublic:
+		return true
+	case ProfileVisibilityUniversity:
+		return viewer.UniversityID == p.UniversityID
+	default:
+		return false
+	}
 }
 
 func (s *UserStore) GetUserByID(ctx context.Context, id int) (User, error) {
 
 	query := `
-	SELECT id, first_name, last_name, email, university
-	FROM users
-	WHERE id = $1
+	SELECT u.id, u.first_name, u.last_name, u.email, u.university_id, un.name, u.email_verified_at IS NOT NULL, u.is_site_admin
+	FROM users u
+	JOIN universities un ON un.id = u.university_id
+	WHERE u.id = $1
 	`
 
 	var fetchedUser User
-	err := s.db.QueryRowContext(ctx, query, id).Scan(&fetchedUser.ID, &fetchedUser.FirstName, &fetchedUser.LastName, &fetchedUser.Email, &fetchedUser.University)
+	err := s.db.QueryRowContext(ctx, query, id).Scan(&fetchedUser.ID, &fetchedUser.FirstName, &fetchedUser.LastName, &fetchedUser.Email, &fetchedUser.UniversityID, &fetchedUser.University, &fetchedUser.EmailVerified, &fetchedUser.SiteAdmin)
 
 	if err != nil {
 		switch {
@@ -56,13 +184,14 @@ func (s *UserStore) GetUserByID(ctx context.Context, id int) (User, error) {
 func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (UserData, error) {
 
 	query := `
-	SELECT id, first_name, last_name, email, university, password_hash
-	FROM users
-	WHERE email = $1
+	SELECT u.id, u.first_name, u.last_name, u.email, u.university_id, un.name, COALESCE(u.password_hash, ''), u.email_verified_at IS NOT NULL
+	FROM users u
+	JOIN universities un ON un.id = u.university_id
+	WHERE u.email = $1
 	`
 
 	var fecthedUser UserData
-	err := s.db.QueryRowContext(ctx, query, email).Scan(&fecthedUser.ID, &fecthedUser.FirstName, &fecthedUser.LastName, &fecthedUser.Email, &fecthedUser.University, &fecthedUser.PasswordHash)
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&fecthedUser.ID, &fecthedUser.FirstName, &fecthedUser.LastName, &fecthedUser.Email, &fecthedUser.UniversityID, &fecthedUser.University, &fecthedUser.PasswordHash, &fecthedUser.EmailVerified)
 
 	if err != nil {
 		switch {
@@ -75,3 +204,130 @@ func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (UserData,
 
 	return fecthedUser, nil
 }
+
+const profileColumns = `u.id, u.first_name, u.last_name, u.university_id, un.name, u.bio, u.pronouns, u.year_of_study, u.course, u.modules, u.profile_visibility, u.shared_profile_fields, COALESCE(u.avatar_key, '')`
+
+func scanProfile(row interface{ Scan(dest ...any) error }, profile *UserProfile) error {
+	return row.Scan(&profile.ID, &profile.FirstName, &profile.LastName, &profile.UniversityID, &profile.University, &profile.Bio, &profile.Pronouns, &profile.YearOfStudy, &profile.Course, pq.Array(&profile.Modules), &profile.Visibility, pq.Array(&profile.SharedFields), &profile.AvatarKey)
+}
+
+func (s *UserStore) GetProfile(ctx context.Context, userID int) (UserProfile, error) {
+	query := `
+		SELECT ` + profileColumns + `
+		FROM users u
+		JOIN universities un ON un.id = u.university_id
+		WHERE u.id = $1
+	`
+
+	var profile UserProfile
+	err := scanProfile(s.db.QueryRowContext(ctx, query, userID), &profile)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return UserProfile{}, ErrNoRows
+		default:
+			return UserProfile{}, err
+		}
+	}
+
+	return profile, nil
+}
+
+// SearchUsers finds the users at the viewer's university whose name, or the
+// course or modules they share, contain the search text. Only profiles the
+// viewer is allowed to see are returned, never the viewer themselves or users
+// blocked either way, ordered by name.
+func (s *UserStore) SearchUsers(ctx context.Context, viewer User, search string, limit int, offset int) ([]UserProfile, error) {
+	query := `
+		SELECT ` + profileColumns + `
+		FROM users u
+		JOIN universities un ON un.id = u.university_id
+		WHERE u.university_id = $1
+			AND u.id != $2
+			AND u.profile_visibility IN ('public', 'university')
+			AND u.email_verified_at IS NOT NULL
+			AND u.deletion_scheduled_at IS NULL
+			AND NOT EXISTS (
+				SELECT 1 FROM user_blocks b
+				WHERE b.kind = 'block'
+					AND ((b.user_id = $2 AND b.blocked_user_id = u.id) OR (b.user_id = u.id AND b.blocked_user_id = $2))
+			)
+			AND (
+				u.first_name || ' ' || u.last_name ILIKE '%' || $3 || '%'
+				OR ('course' = ANY(u.shared_profile_fields) AND u.course ILIKE '%' || $3 || '%')
+				OR ('modules' = ANY(u.shared_profile_fields) AND EXISTS (
+					SELECT 1 FROM unnest(u.modules) AS module WHERE module ILIKE '%' || $3 || '%'
+				))
+			)
+		ORDER BY u.first_name, u.last_name, u.id
+		LIMIT $4 OFFSET $5
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, viewer.UniversityID, viewer.ID, escapeLike(search), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	profiles := []UserProfile{}
+	for rows.Next() {
+		var profile UserProfile
+		err := scanProfile(rows, &profile)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, rows.Err()
+}
+
+// UpdateProfile overwrites every editable field of the user with the profile
+func (s *UserStore) UpdateProfile(ctx context.Context, profile UserProfile) error {
+	query := `
+		UPDATE users
+		SET first_name = $1, last_name = $2, university_id = $3, bio = $4, pronouns = $5, year_of_study = $6,
+			course = $7, modules = $8, profile_visibility = $9, shared_profile_fields = $10, updated_at = NOW()
+		WHERE id = $11
+	`
+
+	result, err := s.db.ExecContext(ctx, query, profile.FirstName, profile.LastName, profile.UniversityID, profile.Bio, profile.Pronouns, profile.YearOfStudy, profile.Course, pq.Array(profile.Modules), profile.Visibility, pq.Array(profile.SharedFields), profile.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrNoRows
+	}
+
+	return nil
+}
+
+// SetAvatar stores the user's new avatar key, or clears it when key is empty,
+// and returns the key it replaced so the old blobs can be deleted
+func (s *UserStore) SetAvatar(ctx context.Context, userID int, key string) (string, error) {
+	query := `
+		UPDATE users u SET avatar_key = NULLIF($1, ''), updated_at = NOW()
+		FROM (SELECT id, avatar_key FROM users WHERE id = $2 FOR UPDATE) old
+		WHERE u.id = old.id
+		RETURNING COALESCE(old.avatar_key, '')
+	`
+
+	var previousKey string
+	err := s.db.QueryRowContext(ctx, query, key, userID).Scan(&previousKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrNoRows
+		default:
+			return "", err
+		}
+	}
+
+	return previousKey, nil
+}
@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B
+var rfcSecret = encoding.EncodeToString([]byte("12345678901234567890"))
+
+// The SHA-1 vectors of RFC 6238 Appendix B, cut to the last 6 of their 8 digits
+func TestCodeRFC6238(t *testing.T) {
+	secret := rfcSecret
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.FormatInt(tt.unix, 10), func(t *testing.T) {
+			got, err := Code(secret, Step(time.Unix(tt.unix, 0)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSkew(t *testing.T) {
+	// A fixed secret, a random one could repeat a code across steps
+	secret := rfcSecret
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		valid  bool
+	}{
+		{"two steps behind", -2, false},
+		{"one step behind", -1, true},
+		{"current step", 0, true},
+		{"one step ahead", 1, true},
+		{"two steps ahead", 2, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := Code(secret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			step, ok := Validate(secret, code, now)
+			if ok != tt.valid {
+				t.Fatalf("got valid %t, want %t", ok, tt.valid)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("matched step %d, want %d", step, current+tt.offset)
+			}
+		})
+	}
+}